@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// audit annotation keys; API server prefixes them with webhook name
+const (
+	roleArnAuditKey       = "role-arn"
+	injectionModeAuditKey = "injection-mode"
+	statusAuditKey        = "status"
+
+	// status audit annotation value for Pods admitted without injection
+	skippedStatus = "skipped"
+)
+
+type auditAnnotationsKey struct{}
+
+// auditAnnotations collects admission response audit annotations
+// (kubewebhook does not support audit annotations, so they are added to response by withAuditAnnotations handler)
+type auditAnnotations struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+// setAuditAnnotation sets admission response audit annotation; noop, if request is not served by withAuditAnnotations handler
+func setAuditAnnotation(ctx context.Context, key, value string) {
+	annotations, ok := ctx.Value(auditAnnotationsKey{}).(*auditAnnotations)
+	if !ok {
+		return
+	}
+	annotations.mu.Lock()
+	defer annotations.mu.Unlock()
+	annotations.values[key] = value
+}
+
+// bufferedResponseWriter buffers response to modify it before writing
+type bufferedResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *bufferedResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// addAuditAnnotations adds audit annotations to AdmissionReview response JSON
+func addAuditAnnotations(body []byte, values map[string]string) ([]byte, error) {
+	var review map[string]json.RawMessage
+	if err := json.Unmarshal(body, &review); err != nil {
+		return nil, err
+	}
+	var response map[string]interface{}
+	if err := json.Unmarshal(review["response"], &response); err != nil {
+		return nil, err
+	}
+	response["auditAnnotations"] = values
+	data, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	review["response"] = data
+	return json.Marshal(review)
+}
+
+// withAuditAnnotations adds audit annotations set by webhook to admission response
+func withAuditAnnotations(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		annotations := &auditAnnotations{values: make(map[string]string)}
+		buffered := &bufferedResponseWriter{header: w.Header()}
+		next.ServeHTTP(buffered, r.WithContext(context.WithValue(r.Context(), auditAnnotationsKey{}, annotations)))
+		if buffered.status == 0 {
+			buffered.status = http.StatusOK
+		}
+		body := buffered.body.Bytes()
+		if buffered.status == http.StatusOK && len(annotations.values) > 0 {
+			data, err := addAuditAnnotations(body, annotations.values)
+			if err != nil {
+				logger.WithError(err).Warn("failed to add audit annotations to admission response")
+			} else {
+				body = data
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			}
+		}
+		w.WriteHeader(buffered.status)
+		if _, err := w.Write(body); err != nil {
+			logger.WithError(err).Error("failed to write admission response")
+		}
+	})
+}
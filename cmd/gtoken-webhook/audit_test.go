@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cmp "github.com/google/go-cmp/cmp"
+)
+
+func Test_withAuditAnnotations(t *testing.T) {
+	const review = `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","response":{"uid":"1234","allowed":true}}`
+	tests := []struct {
+		name        string
+		status      int
+		annotations map[string]string
+		want        map[string]string
+	}{
+		{name: "no annotations", status: http.StatusOK},
+		{
+			name:        "annotations",
+			status:      http.StatusOK,
+			annotations: map[string]string{roleArnAuditKey: "arn:aws:iam::123456789012:role/app", statusAuditKey: injectedStatus},
+			want:        map[string]string{roleArnAuditKey: "arn:aws:iam::123456789012:role/app", statusAuditKey: injectedStatus},
+		},
+		{
+			name:        "error response",
+			status:      http.StatusInternalServerError,
+			annotations: map[string]string{statusAuditKey: injectedStatus},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := withAuditAnnotations(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for key, value := range tt.annotations {
+					setAuditAnnotation(r.Context(), key, value)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(review))
+			}))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/pods", nil))
+			if w.Code != tt.status {
+				t.Errorf("withAuditAnnotations() status = %d, want %d", w.Code, tt.status)
+			}
+			var got struct {
+				Response struct {
+					UID              string            `json:"uid"`
+					AuditAnnotations map[string]string `json:"auditAnnotations"`
+				} `json:"response"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Response.UID != "1234" {
+				t.Errorf("withAuditAnnotations() response uid = %q, want 1234", got.Response.UID)
+			}
+			if !cmp.Equal(got.Response.AuditAnnotations, tt.want) {
+				t.Errorf("withAuditAnnotations() audit annotations = diff %v", cmp.Diff(got.Response.AuditAnnotations, tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// Pod, Service Account or Namespace annotation key; AWS region
+	awsRegionAnnotationKey = "gtoken.doit-intl.com/aws-region"
+	// Pod, Service Account or Namespace annotation key; set to "true" to use regional AWS STS endpoint
+	stsRegionalEndpointsAnnotationKey = "gtoken.doit-intl.com/sts-regional-endpoints"
+	// Pod, Service Account or Namespace annotation key; AWS STS endpoint URL
+	stsEndpointAnnotationKey = "gtoken.doit-intl.com/sts-endpoint"
+
+	// AWS region and STS endpoint ENV
+	awsRegion               = "AWS_REGION"
+	awsDefaultRegion        = "AWS_DEFAULT_REGION"
+	awsStsRegionalEndpoints = "AWS_STS_REGIONAL_ENDPOINTS"
+	awsEndpointURLSts       = "AWS_ENDPOINT_URL_STS"
+)
+
+// awsConfig holds AWS region and STS endpoint settings to inject into Pod containers
+type awsConfig struct {
+	region               string
+	stsRegionalEndpoints bool
+	stsEndpoint          string
+}
+
+// merge returns AWS config overridden with annotation values
+func (c awsConfig) merge(annotations map[string]string) awsConfig {
+	if value, ok := annotations[awsRegionAnnotationKey]; ok {
+		c.region = value
+	}
+	if value, ok := annotations[stsRegionalEndpointsAnnotationKey]; ok {
+		regional, err := strconv.ParseBool(value)
+		if err != nil {
+			logger.WithField("value", value).Warnf("ignoring invalid %s annotation", stsRegionalEndpointsAnnotationKey)
+		} else {
+			c.stsRegionalEndpoints = regional
+		}
+	}
+	if value, ok := annotations[stsEndpointAnnotationKey]; ok {
+		c.stsEndpoint = value
+	}
+	return c
+}
+
+// mergeBinding returns AWS config overridden with GTokenBinding values
+func (c awsConfig) mergeBinding(binding *gtokenBinding) awsConfig {
+	if binding != nil && binding.Spec.Region != "" {
+		c.region = binding.Spec.Region
+	}
+	return c
+}
+
+// env returns AWS environment variables for AWS config
+func (c awsConfig) env() []corev1.EnvVar {
+	var env []corev1.EnvVar
+	if c.region != "" {
+		env = append(env, corev1.EnvVar{Name: awsRegion, Value: c.region}, corev1.EnvVar{Name: awsDefaultRegion, Value: c.region})
+	}
+	if c.stsRegionalEndpoints {
+		env = append(env, corev1.EnvVar{Name: awsStsRegionalEndpoints, Value: "regional"})
+	}
+	if c.stsEndpoint != "" {
+		env = append(env, corev1.EnvVar{Name: awsEndpointURLSts, Value: c.stsEndpoint})
+	}
+	return env
+}
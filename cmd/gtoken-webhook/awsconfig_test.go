@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	cmp "github.com/google/go-cmp/cmp"
+	whmodel "github.com/slok/kubewebhook/v2/pkg/model"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+//nolint:funlen
+func Test_mutatingWebhook_mutatePod_awsConfig(t *testing.T) {
+	tests := []struct {
+		name           string
+		awsConfig      awsConfig
+		saAnnotations  map[string]string
+		podAnnotations map[string]string
+		want           map[string]string
+	}{
+		{
+			name: "no region",
+			want: map[string]string{},
+		},
+		{
+			name:      "webhook defaults",
+			awsConfig: awsConfig{region: "us-west-2", stsRegionalEndpoints: true},
+			want: map[string]string{
+				awsRegion:               "us-west-2",
+				awsDefaultRegion:        "us-west-2",
+				awsStsRegionalEndpoints: "regional",
+			},
+		},
+		{
+			name:      "service account annotations",
+			awsConfig: awsConfig{region: "us-west-2"},
+			saAnnotations: map[string]string{
+				awsRegionAnnotationKey:            "eu-west-1",
+				stsRegionalEndpointsAnnotationKey: "true",
+				stsEndpointAnnotationKey:          "https://sts.eu-west-1.amazonaws.com",
+			},
+			want: map[string]string{
+				awsRegion:               "eu-west-1",
+				awsDefaultRegion:        "eu-west-1",
+				awsStsRegionalEndpoints: "regional",
+				awsEndpointURLSts:       "https://sts.eu-west-1.amazonaws.com",
+			},
+		},
+		{
+			name:      "pod annotations override service account annotations",
+			awsConfig: awsConfig{stsRegionalEndpoints: true},
+			saAnnotations: map[string]string{
+				awsRegionAnnotationKey: "eu-west-1",
+			},
+			podAnnotations: map[string]string{
+				awsRegionAnnotationKey:            "ap-southeast-1",
+				stsRegionalEndpointsAnnotationKey: "false",
+			},
+			want: map[string]string{
+				awsRegion:        "ap-southeast-1",
+				awsDefaultRegion: "ap-southeast-1",
+			},
+		},
+		{
+			name:           "invalid regional endpoints annotation",
+			awsConfig:      awsConfig{stsRegionalEndpoints: true},
+			podAnnotations: map[string]string{stsRegionalEndpointsAnnotationKey: "maybe"},
+			want:           map[string]string{awsStsRegionalEndpoints: "regional"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saAnnotations := map[string]string{awsRoleArnKey: "arn:aws:iam::123456789012:role/sa-role"}
+			for k, v := range tt.saAnnotations {
+				saAnnotations[k] = v
+			}
+			sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "test-sa", Namespace: "test-namespace", Annotations: saAnnotations}}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Annotations: tt.podAnnotations},
+				Spec: corev1.PodSpec{
+					Containers:         []corev1.Container{{Name: "app"}},
+					ServiceAccountName: "test-sa",
+				},
+			}
+			mw := &mutatingWebhook{
+				k8sClient:  fake.NewSimpleClientset(sa),
+				volumeName: tokenVolumeName,
+				volumePath: tokenVolumePath,
+				tokenFile:  tokenFileName,
+				awsConfig:  tt.awsConfig,
+			}
+			if _, err := mw.mutatePod(context.TODO(), pod, &whmodel.AdmissionReview{Namespace: "test-namespace"}); err != nil {
+				t.Fatalf("mutatingWebhook.mutatePod() error = %v", err)
+			}
+			got := make(map[string]string)
+			for _, env := range pod.Spec.Containers[0].Env {
+				switch env.Name {
+				case awsWebIdentityTokenFile, awsRoleArn, awsRoleSessionName:
+				default:
+					got[env.Name] = env.Value
+				}
+			}
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("mutatingWebhook.mutatePod() env = diff %v", cmp.Diff(got, tt.want))
+			}
+		})
+	}
+}
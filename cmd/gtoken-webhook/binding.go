@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// GTokenBinding Ready condition type and reasons
+	bindingReadyCondition = "Ready"
+	bindingValidReason    = "Valid"
+	bindingInvalidReason  = "InvalidSpec"
+)
+
+var (
+	// GTokenBinding custom resource
+	bindingGVR = schema.GroupVersionResource{Group: "gtoken.doit-intl.com", Version: "v1alpha1", Resource: "gtokenbindings"}
+	// AWS IAM role ARN: arn:<partition>:iam::<account>:role/<path/name>
+	roleArnRegexp = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:role/[\w+=,.@/-]{1,512}$`)
+)
+
+// gtokenBinding maps Service Accounts and Pods (selected by labels) in the namespace to AWS role
+type gtokenBinding struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   gtokenBindingSpec   `json:"spec"`
+	Status gtokenBindingStatus `json:"status,omitempty"`
+}
+
+type gtokenBindingSpec struct {
+	// Service Account label selector (all Service Accounts, if not specified)
+	ServiceAccountSelector *metav1.LabelSelector `json:"serviceAccountSelector,omitempty"`
+	// Pod label selector (all Pods, if not specified)
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+	// AWS IAM role ARN
+	RoleArn string `json:"roleArn"`
+	// ID token audience
+	Audience string `json:"audience,omitempty"`
+	// AWS region
+	Region string `json:"region,omitempty"`
+	// injection mode: native-sidecar, sidecar or init-only
+	Mode string `json:"mode,omitempty"`
+}
+
+type gtokenBindingStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// validateRoleArn returns error if value is not a valid AWS IAM role ARN
+func validateRoleArn(value string) error {
+	if !roleArnRegexp.MatchString(value) {
+		return fmt.Errorf("invalid AWS role ARN %q", value)
+	}
+	return nil
+}
+
+func (b *gtokenBinding) validate() error {
+	if err := validateRoleArn(b.Spec.RoleArn); err != nil {
+		return err
+	}
+	if b.Spec.Mode != "" {
+		if _, err := parseInjectionMode(b.Spec.Mode); err != nil {
+			return err
+		}
+	}
+	for _, selector := range []*metav1.LabelSelector{b.Spec.ServiceAccountSelector, b.Spec.PodSelector} {
+		if _, err := metav1.LabelSelectorAsSelector(selector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches returns true if binding selects Pod and its Service Account
+func (b *gtokenBinding) matches(pod *corev1.Pod, sa *corev1.ServiceAccount) bool {
+	return selectorMatches(b.Spec.ServiceAccountSelector, sa.GetLabels()) && selectorMatches(b.Spec.PodSelector, pod.GetLabels())
+}
+
+func selectorMatches(labelSelector *metav1.LabelSelector, objLabels map[string]string) bool {
+	if labelSelector == nil {
+		return true
+	}
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(objLabels))
+}
+
+// readyCondition returns binding Ready condition for current spec
+func (b *gtokenBinding) readyCondition() metav1.Condition {
+	condition := metav1.Condition{
+		Type:               bindingReadyCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: b.Generation,
+		Reason:             bindingValidReason,
+		Message:            "binding is valid",
+	}
+	if err := b.validate(); err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = bindingInvalidReason
+		condition.Message = err.Error()
+	}
+	return condition
+}
+
+// bindingWatcher watches GTokenBinding resources and maintains their status
+type bindingWatcher struct {
+	client   dynamic.Interface
+	factory  dynamicinformer.DynamicSharedInformerFactory
+	informer cache.SharedIndexInformer
+	lister   cache.GenericLister
+}
+
+func newBindingWatcher(client dynamic.Interface) (*bindingWatcher, error) {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, 0)
+	informer := factory.ForResource(bindingGVR)
+	w := &bindingWatcher{client: client, factory: factory, informer: informer.Informer(), lister: informer.Lister()}
+	_, err := w.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    w.updateStatus,
+		UpdateFunc: func(_, obj interface{}) { w.updateStatus(obj) },
+	})
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// start informer and wait for initial cache sync
+func (w *bindingWatcher) start(stopCh <-chan struct{}) bool {
+	w.factory.Start(stopCh)
+	return cache.WaitForCacheSync(stopCh, w.informer.HasSynced)
+}
+
+// synced returns true if GTokenBinding cache is synced
+func (w *bindingWatcher) synced() bool {
+	return w.informer.HasSynced()
+}
+
+func toBinding(obj interface{}) (*gtokenBinding, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T", obj)
+	}
+	binding := &gtokenBinding{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, binding); err != nil {
+		return nil, err
+	}
+	return binding, nil
+}
+
+// updateStatus sets binding Ready condition, if changed; on conflict (for example, status updated by another
+// webhook replica) retries with the latest binding version
+func (w *bindingWatcher) updateStatus(obj interface{}) {
+	binding, err := toBinding(obj)
+	if err != nil {
+		logger.WithError(err).Error("failed to convert GTokenBinding")
+		return
+	}
+	resource := w.client.Resource(bindingGVR).Namespace(binding.Namespace)
+	// the first attempt uses informer object; retries get the latest version
+	refetch := false
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if refetch {
+			u, getErr := resource.Get(context.Background(), binding.Name, metav1.GetOptions{})
+			if getErr != nil {
+				return getErr
+			}
+			current, convErr := toBinding(u)
+			if convErr != nil {
+				return convErr
+			}
+			binding = current
+		}
+		refetch = true
+		return w.setReadyCondition(binding)
+	})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		logger.WithField("binding", binding.Namespace+"/"+binding.Name).WithError(err).Warn("failed to update GTokenBinding status")
+	}
+}
+
+// setReadyCondition updates binding status with Ready condition, if changed
+func (w *bindingWatcher) setReadyCondition(binding *gtokenBinding) error {
+	condition := binding.readyCondition()
+	current := meta.FindStatusCondition(binding.Status.Conditions, bindingReadyCondition)
+	if current != nil && current.Status == condition.Status && current.Reason == condition.Reason &&
+		current.Message == condition.Message && binding.Status.ObservedGeneration == binding.Generation {
+		return nil
+	}
+	meta.SetStatusCondition(&binding.Status.Conditions, condition)
+	binding.Status.ObservedGeneration = binding.Generation
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(binding)
+	if err != nil {
+		return err
+	}
+	_, err = w.client.Resource(bindingGVR).Namespace(binding.Namespace).
+		UpdateStatus(context.Background(), &unstructured.Unstructured{Object: u}, metav1.UpdateOptions{})
+	return err
+}
+
+// match returns first valid binding (ordered by name) in namespace that selects Pod and its Service Account; nil if none
+func (w *bindingWatcher) match(ns string, pod *corev1.Pod, sa *corev1.ServiceAccount) (*gtokenBinding, error) {
+	objs, err := w.lister.ByNamespace(ns).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	bindings := make([]*gtokenBinding, 0, len(objs))
+	for _, obj := range objs {
+		binding, err := toBinding(obj)
+		if err != nil {
+			return nil, err
+		}
+		bindings = append(bindings, binding)
+	}
+	sort.Slice(bindings, func(i, j int) bool { return bindings[i].Name < bindings[j].Name })
+	for _, binding := range bindings {
+		if err := binding.validate(); err != nil {
+			logger.WithField("binding", ns+"/"+binding.Name).WithError(err).Debug("skipping invalid GTokenBinding")
+			continue
+		}
+		if binding.matches(pod, sa) {
+			return binding, nil
+		}
+	}
+	return nil, nil
+}
+
+// get GTokenBinding for Pod; nil if GTokenBinding support is disabled or no binding matches
+func (mw *mutatingWebhook) getBinding(pod *corev1.Pod, sa *corev1.ServiceAccount, ns string) (*gtokenBinding, error) {
+	if mw.bindings == nil {
+		return nil, nil
+	}
+	return mw.bindings.match(ns, pod, sa)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	cmp "github.com/google/go-cmp/cmp"
+	whmodel "github.com/slok/kubewebhook/v2/pkg/model"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	fake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newBinding(t *testing.T, name string, spec gtokenBindingSpec) *unstructured.Unstructured {
+	binding := &gtokenBinding{
+		TypeMeta:   metav1.TypeMeta{APIVersion: bindingGVR.GroupVersion().String(), Kind: "GTokenBinding"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test-namespace", Generation: 1},
+		Spec:       spec,
+	}
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(binding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &unstructured.Unstructured{Object: u}
+}
+
+func newFakeBindingWatcher(t *testing.T, objs ...runtime.Object) (*bindingWatcher, *dynamicfake.FakeDynamicClient) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{bindingGVR: "GTokenBindingList"}, objs...)
+	w, err := newBindingWatcher(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	if !w.start(stopCh) {
+		t.Fatal("bindingWatcher.start() failed to sync")
+	}
+	return w, client
+}
+
+func Test_gtokenBinding_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    gtokenBindingSpec
+		wantErr bool
+	}{
+		{name: "valid", spec: gtokenBindingSpec{RoleArn: "arn:aws:iam::123456789012:role/app"}},
+		{name: "role path", spec: gtokenBindingSpec{RoleArn: "arn:aws-us-gov:iam::123456789012:role/team/app"}},
+		{name: "invalid account", spec: gtokenBindingSpec{RoleArn: "arn:aws:iam::1234:role/app"}, wantErr: true},
+		{name: "not a role", spec: gtokenBindingSpec{RoleArn: "arn:aws:iam::123456789012:user/app"}, wantErr: true},
+		{name: "invalid mode", spec: gtokenBindingSpec{RoleArn: "arn:aws:iam::123456789012:role/app", Mode: "daemon"}, wantErr: true},
+		{
+			name: "invalid selector",
+			spec: gtokenBindingSpec{
+				RoleArn:     "arn:aws:iam::123456789012:role/app",
+				PodSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Like"}}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &gtokenBinding{Spec: tt.spec}
+			if err := b.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("gtokenBinding.validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_bindingWatcher_updateStatus(t *testing.T) {
+	_, client := newFakeBindingWatcher(t,
+		newBinding(t, "valid", gtokenBindingSpec{RoleArn: "arn:aws:iam::123456789012:role/app"}),
+		newBinding(t, "invalid", gtokenBindingSpec{RoleArn: "not-an-arn"}))
+	for name, want := range map[string]metav1.ConditionStatus{"valid": metav1.ConditionTrue, "invalid": metav1.ConditionFalse} {
+		var condition *metav1.Condition
+		// status is updated by informer event handler
+		for i := 0; i < 50 && condition == nil; i++ {
+			u, err := client.Resource(bindingGVR).Namespace("test-namespace").Get(context.TODO(), name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			binding, err := toBinding(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if condition = meta.FindStatusCondition(binding.Status.Conditions, bindingReadyCondition); condition == nil {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		if condition == nil || condition.Status != want {
+			t.Errorf("binding %s Ready condition = %v, want %s", name, condition, want)
+		}
+	}
+}
+
+func Test_bindingWatcher_updateStatus_conflict(t *testing.T) {
+	tests := []struct {
+		name string
+		// another replica updated binding status before conflict
+		updatedByReplica bool
+		wantUpdates      int
+	}{
+		{name: "retry on conflict", wantUpdates: 2},
+		{name: "status already updated by another replica", updatedByReplica: true, wantUpdates: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stale := newBinding(t, "valid", gtokenBindingSpec{RoleArn: "arn:aws:iam::123456789012:role/app"})
+			stored := stale.DeepCopy()
+			if tt.updatedByReplica {
+				binding, err := toBinding(stored)
+				if err != nil {
+					t.Fatal(err)
+				}
+				binding.Status.ObservedGeneration = binding.Generation
+				meta.SetStatusCondition(&binding.Status.Conditions, binding.readyCondition())
+				u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(binding)
+				if err != nil {
+					t.Fatal(err)
+				}
+				stored = &unstructured.Unstructured{Object: u}
+			}
+			client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+				map[schema.GroupVersionResource]string{bindingGVR: "GTokenBindingList"}, stored)
+			var updates int
+			client.PrependReactor("update", "gtokenbindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if action.GetSubresource() != "status" {
+					return false, nil, nil
+				}
+				if updates++; updates == 1 {
+					return true, nil, k8serrors.NewConflict(bindingGVR.GroupResource(), "valid", errors.New("object has been modified"))
+				}
+				return false, nil, nil
+			})
+			w := &bindingWatcher{client: client}
+			w.updateStatus(stale)
+			if updates != tt.wantUpdates {
+				t.Errorf("bindingWatcher.updateStatus() status updates = %d, want %d", updates, tt.wantUpdates)
+			}
+			u, err := client.Resource(bindingGVR).Namespace("test-namespace").Get(context.TODO(), "valid", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			binding, err := toBinding(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if condition := meta.FindStatusCondition(binding.Status.Conditions, bindingReadyCondition); condition == nil || condition.Status != metav1.ConditionTrue {
+				t.Errorf("binding Ready condition = %v, want %s", condition, metav1.ConditionTrue)
+			}
+		})
+	}
+}
+
+//nolint:funlen
+func Test_mutatingWebhook_mutatePod_binding(t *testing.T) {
+	const (
+		saRoleArn      = "arn:aws:iam::123456789012:role/sa-role"
+		bindingRoleArn = "arn:aws:iam::123456789012:role/binding-role"
+		podRoleArn     = "arn:aws:iam::123456789012:role/pod-role"
+	)
+	tests := []struct {
+		name           string
+		bindings       []runtime.Object
+		saLabels       map[string]string
+		podAnnotations map[string]string
+		wantRoleArn    string
+		wantRegion     string
+		wantCommand    []string
+	}{
+		{
+			name:        "no binding",
+			wantRoleArn: saRoleArn,
+			wantCommand: []string{"/gtoken", "--file=/var/run/secrets/aws/token/gtoken", "--refresh=false"},
+		},
+		{
+			name: "binding",
+			bindings: []runtime.Object{newBinding(t, "app", gtokenBindingSpec{
+				ServiceAccountSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "data"}},
+				RoleArn:                bindingRoleArn,
+				Audience:               "sts.amazonaws.com",
+				Region:                 "eu-west-1",
+				Mode:                   string(initOnlyMode),
+			})},
+			saLabels:    map[string]string{"team": "data"},
+			wantRoleArn: bindingRoleArn,
+			wantRegion:  "eu-west-1",
+			wantCommand: []string{"/gtoken", "--file=/var/run/secrets/aws/token/gtoken", "--refresh=false", "--audience=sts.amazonaws.com"},
+		},
+		{
+			name: "binding selector does not match",
+			bindings: []runtime.Object{newBinding(t, "app", gtokenBindingSpec{
+				ServiceAccountSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "data"}},
+				RoleArn:                bindingRoleArn,
+			})},
+			saLabels:    map[string]string{"team": "web"},
+			wantRoleArn: saRoleArn,
+			wantCommand: []string{"/gtoken", "--file=/var/run/secrets/aws/token/gtoken", "--refresh=false"},
+		},
+		{
+			name:        "invalid binding is ignored",
+			bindings:    []runtime.Object{newBinding(t, "app", gtokenBindingSpec{RoleArn: "not-an-arn"})},
+			wantRoleArn: saRoleArn,
+			wantCommand: []string{"/gtoken", "--file=/var/run/secrets/aws/token/gtoken", "--refresh=false"},
+		},
+		{
+			name:           "pod annotation overrides binding",
+			bindings:       []runtime.Object{newBinding(t, "app", gtokenBindingSpec{RoleArn: bindingRoleArn})},
+			podAnnotations: map[string]string{roleArnAnnotationKey: podRoleArn},
+			wantRoleArn:    podRoleArn,
+			wantCommand:    []string{"/gtoken", "--file=/var/run/secrets/aws/token/gtoken", "--refresh=false"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bindings, _ := newFakeBindingWatcher(t, tt.bindings...)
+			sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-sa",
+				Namespace:   "test-namespace",
+				Labels:      tt.saLabels,
+				Annotations: map[string]string{awsRoleArnKey: saRoleArn},
+			}}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Annotations: tt.podAnnotations},
+				Spec: corev1.PodSpec{
+					Containers:         []corev1.Container{{Name: "app"}},
+					ServiceAccountName: "test-sa",
+				},
+			}
+			mw := &mutatingWebhook{
+				k8sClient:          fake.NewSimpleClientset(sa),
+				bindings:           bindings,
+				volumeName:         tokenVolumeName,
+				volumePath:         tokenVolumePath,
+				tokenFile:          tokenFileName,
+				podRoleArnOverride: true,
+			}
+			if _, err := mw.mutatePod(context.TODO(), pod, &whmodel.AdmissionReview{Namespace: "test-namespace"}); err != nil {
+				t.Fatalf("mutatingWebhook.mutatePod() error = %v", err)
+			}
+			if got := getRoleArnEnv(pod); got != tt.wantRoleArn {
+				t.Errorf("mutatingWebhook.mutatePod() role ARN = %v, want %v", got, tt.wantRoleArn)
+			}
+			var region string
+			for _, env := range pod.Spec.Containers[0].Env {
+				if env.Name == awsRegion {
+					region = env.Value
+				}
+			}
+			if region != tt.wantRegion {
+				t.Errorf("mutatingWebhook.mutatePod() region = %v, want %v", region, tt.wantRegion)
+			}
+			if got := pod.Spec.InitContainers[0].Command; !cmp.Equal(got, tt.wantCommand) {
+				t.Errorf("mutatingWebhook.mutatePod() init container command = %v, want %v", got, tt.wantCommand)
+			}
+		})
+	}
+}
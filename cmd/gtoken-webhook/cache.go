@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+const (
+	// cache lookup results
+	cacheHit  = "hit"
+	cacheMiss = "miss"
+)
+
+var cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gtoken_webhook",
+	Name:      "cache_requests_total",
+	Help:      "Number of informer cache lookups by resource and result (hit or miss).",
+}, []string{"resource", "result"})
+
+// k8sCache is informer-backed cache of Service Accounts and Namespaces; falls back to live GET on cache miss
+type k8sCache struct {
+	k8sClient       kubernetes.Interface
+	factory         informers.SharedInformerFactory
+	serviceAccounts corelisters.ServiceAccountLister
+	namespaces      corelisters.NamespaceLister
+}
+
+func newK8sCache(k8sClient kubernetes.Interface) *k8sCache {
+	factory := informers.NewSharedInformerFactory(k8sClient, 0)
+	return &k8sCache{
+		k8sClient:       k8sClient,
+		factory:         factory,
+		serviceAccounts: factory.Core().V1().ServiceAccounts().Lister(),
+		namespaces:      factory.Core().V1().Namespaces().Lister(),
+	}
+}
+
+// start informers and wait for initial cache sync
+func (c *k8sCache) start(stopCh <-chan struct{}) bool {
+	c.factory.Start(stopCh)
+	for informer, synced := range c.factory.WaitForCacheSync(stopCh) {
+		if !synced {
+			logger.WithField("informer", informer.String()).Error("failed to sync informer cache")
+			return false
+		}
+	}
+	return true
+}
+
+// synced returns true if informer caches are synced
+func (c *k8sCache) synced() bool {
+	return c.factory.Core().V1().ServiceAccounts().Informer().HasSynced() &&
+		c.factory.Core().V1().Namespaces().Informer().HasSynced()
+}
+
+// get Service Account from cache; use live GET on cache miss (freshly created Service Account);
+// returns lookup source: cache or api
+func (c *k8sCache) getServiceAccount(ctx context.Context, name, ns string) (*corev1.ServiceAccount, string, error) {
+	sa, err := c.serviceAccounts.ServiceAccounts(ns).Get(name)
+	if err == nil {
+		cacheRequests.WithLabelValues("serviceaccount", cacheHit).Inc()
+		return sa, cacheSource, nil
+	}
+	if !k8serrors.IsNotFound(err) {
+		return nil, cacheSource, err
+	}
+	cacheRequests.WithLabelValues("serviceaccount", cacheMiss).Inc()
+	sa, err = c.k8sClient.CoreV1().ServiceAccounts(ns).Get(ctx, name, metav1.GetOptions{})
+	return sa, apiSource, err
+}
+
+// get Namespace from cache; use live GET on cache miss (freshly created Namespace)
+func (c *k8sCache) getNamespace(ctx context.Context, name string) (*corev1.Namespace, error) {
+	namespace, err := c.namespaces.Get(name)
+	if err == nil {
+		cacheRequests.WithLabelValues("namespace", cacheHit).Inc()
+		return namespace, nil
+	}
+	if !k8serrors.IsNotFound(err) {
+		return nil, err
+	}
+	cacheRequests.WithLabelValues("namespace", cacheMiss).Inc()
+	return c.k8sClient.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_k8sCache_getServiceAccount(t *testing.T) {
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "test-sa", Namespace: "test-namespace"}}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-namespace"}}
+	client := fake.NewSimpleClientset(sa, ns)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	// cache miss: informers are not started, fall back to live GET
+	c := newK8sCache(client)
+	misses := testutil.ToFloat64(cacheRequests.WithLabelValues("serviceaccount", cacheMiss))
+	if _, source, err := c.getServiceAccount(context.TODO(), "test-sa", "test-namespace"); err != nil || source != apiSource {
+		t.Fatalf("k8sCache.getServiceAccount() cache miss = %s, %v; want %s source", source, err, apiSource)
+	}
+	if got := testutil.ToFloat64(cacheRequests.WithLabelValues("serviceaccount", cacheMiss)); got != misses+1 {
+		t.Errorf("cache misses = %v, want %v", got, misses+1)
+	}
+
+	// cache hit
+	c = newK8sCache(client)
+	if !c.start(stopCh) {
+		t.Fatal("k8sCache.start() failed to sync")
+	}
+	hits := testutil.ToFloat64(cacheRequests.WithLabelValues("serviceaccount", cacheHit))
+	if _, source, err := c.getServiceAccount(context.TODO(), "test-sa", "test-namespace"); err != nil || source != cacheSource {
+		t.Fatalf("k8sCache.getServiceAccount() cache hit = %s, %v; want %s source", source, err, cacheSource)
+	}
+	if got := testutil.ToFloat64(cacheRequests.WithLabelValues("serviceaccount", cacheHit)); got != hits+1 {
+		t.Errorf("cache hits = %v, want %v", got, hits+1)
+	}
+	if _, err := c.getNamespace(context.TODO(), "test-namespace"); err != nil {
+		t.Fatalf("k8sCache.getNamespace() error = %v", err)
+	}
+
+	// missing Service Account
+	if _, _, err := c.getServiceAccount(context.TODO(), "missing-sa", "test-namespace"); err == nil {
+		t.Error("k8sCache.getServiceAccount() expected error for missing Service Account")
+	}
+}
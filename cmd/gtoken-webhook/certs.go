@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// default interval to check TLS certificate files for changes
+const defaultTLSReloadInterval = 10 * time.Second
+
+var certExpiry = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: "gtoken_webhook",
+	Name:      "tls_certificate_expiry_timestamp_seconds",
+	Help:      "Expiration time of the webhook TLS serving certificate (Unix seconds).",
+})
+
+// supported TLS versions
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// certProvider serves TLS certificate loaded from files (or self-managed Secret) and reloads it when files change
+type certProvider struct {
+	certFile string
+	keyFile  string
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	// loaded certificate and key files content
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newCertProvider creates certificate provider and loads certificate
+func newCertProvider(certFile, keyFile string) (*certProvider, error) {
+	p := &certProvider{certFile: certFile, keyFile: keyFile}
+	if _, err := p.reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// reload certificate, if certificate or key file changed; returns true if certificate was reloaded
+func (p *certProvider) reload() (bool, error) {
+	certPEM, err := os.ReadFile(p.certFile)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read TLS certificate file")
+	}
+	keyPEM, err := os.ReadFile(p.keyFile)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read TLS private key file")
+	}
+	return p.update(certPEM, keyPEM)
+}
+
+// update certificate, if certificate or key changed; returns true if certificate was updated
+func (p *certProvider) update(certPEM, keyPEM []byte) (bool, error) {
+	p.mu.RLock()
+	unchanged := bytes.Equal(certPEM, p.certPEM) && bytes.Equal(keyPEM, p.keyPEM)
+	p.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		// certificate and key files may be updated not at once
+		return false, errors.Wrap(err, "failed to load TLS certificate")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false, errors.Wrap(err, "failed to parse TLS certificate")
+	}
+	cert.Leaf = leaf
+	p.mu.Lock()
+	p.cert, p.certPEM, p.keyPEM = &cert, certPEM, keyPEM
+	p.mu.Unlock()
+	certExpiry.Set(float64(leaf.NotAfter.Unix()))
+	logger.WithField("expiry", leaf.NotAfter).Info("loaded TLS certificate")
+	return true, nil
+}
+
+// watch certificate files for changes, until stop channel is closed
+func (p *certProvider) watch(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			if _, err := p.reload(); err != nil {
+				logger.WithError(err).Warn("failed to reload TLS certificate, keep serving current certificate")
+			}
+		}
+	}
+}
+
+// GetCertificate returns current certificate; used as tls.Config GetCertificate
+func (p *certProvider) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.cert == nil {
+		return nil, errors.New("TLS certificate is not loaded yet")
+	}
+	return p.cert, nil
+}
+
+// loaded returns true if certificate is loaded
+func (p *certProvider) loaded() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.cert != nil
+}
+
+// newTLSConfig creates TLS config with minimal TLS version and cipher suites (Go defaults, if empty)
+func newTLSConfig(provider *certProvider, minVersion string, cipherSuites []string) (*tls.Config, error) {
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("unsupported TLS version %q", minVersion)
+	}
+	config := &tls.Config{
+		MinVersion:     version,
+		GetCertificate: provider.GetCertificate,
+	}
+	if len(cipherSuites) == 0 {
+		return config, nil
+	}
+	// only secure cipher suites are allowed
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	for _, name := range cipherSuites {
+		id, ok := suites[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported TLS cipher suite %q", name)
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+	return config, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes self-signed certificate and private key files, expiring after ttl
+func writeTestCert(t *testing.T, certFile, keyFile string, ttl time.Duration) time.Time {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notAfter := time.Now().Add(ttl).Truncate(time.Second)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "gtoken-webhook-svc.gtoken.svc"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return notAfter
+}
+
+func Test_certProvider_reload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, time.Hour)
+	p, err := newCertProvider(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newCertProvider() error = %v", err)
+	}
+	if reloaded, err := p.reload(); err != nil || reloaded {
+		t.Errorf("certProvider.reload() = %v, %v; want no reload for unchanged files", reloaded, err)
+	}
+	// rotate certificate
+	notAfter := writeTestCert(t, certFile, keyFile, 2*time.Hour)
+	if reloaded, err := p.reload(); err != nil || !reloaded {
+		t.Fatalf("certProvider.reload() = %v, %v; want reload for changed files", reloaded, err)
+	}
+	cert, err := p.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.Leaf.NotAfter.Equal(notAfter) {
+		t.Errorf("certProvider.GetCertificate() expiry = %v, want %v", cert.Leaf.NotAfter, notAfter)
+	}
+	// keep serving current certificate on broken files
+	if err = os.WriteFile(keyFile, []byte("broken"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.reload(); err == nil {
+		t.Error("certProvider.reload() expected error for invalid private key")
+	}
+	if current, _ := p.GetCertificate(&tls.ClientHelloInfo{}); current != cert {
+		t.Error("certProvider.GetCertificate() changed certificate after failed reload")
+	}
+}
+
+func Test_newTLSConfig(t *testing.T) {
+	tests := []struct {
+		name         string
+		minVersion   string
+		cipherSuites []string
+		wantVersion  uint16
+		wantSuites   int
+		wantErr      bool
+	}{
+		{name: "defaults", minVersion: "1.2", wantVersion: tls.VersionTLS12},
+		{name: "TLS 1.3", minVersion: "1.3", wantVersion: tls.VersionTLS13},
+		{
+			name:         "cipher suites",
+			minVersion:   "1.2",
+			cipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+			wantVersion:  tls.VersionTLS12,
+			wantSuites:   2,
+		},
+		{name: "unsupported version", minVersion: "2.0", wantErr: true},
+		{name: "insecure cipher suite", minVersion: "1.2", cipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newTLSConfig(&certProvider{}, tt.minVersion, tt.cipherSuites)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newTLSConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.MinVersion != tt.wantVersion || len(got.CipherSuites) != tt.wantSuites {
+				t.Errorf("newTLSConfig() = version %x, %d cipher suites; want %x, %d", got.MinVersion, len(got.CipherSuites), tt.wantVersion, tt.wantSuites)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	whmodel "github.com/slok/kubewebhook/v2/pkg/model"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// event source component
+	eventComponent = "gtoken-webhook"
+
+	// event reasons
+	roleNotAllowedReason = "AWSRoleNotAllowed"
+	injectedReason       = "GTokenInjected"
+	skippedReason        = "GTokenSkipped"
+)
+
+// newEventRecorder creates K8s event recorder
+func newEventRecorder(client kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: eventComponent})
+}
+
+// record K8s event for object; noop, if event recorder is not configured
+func (mw *mutatingWebhook) eventf(obj runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if mw.recorder == nil {
+		return
+	}
+	mw.recorder.Eventf(obj, eventType, reason, messageFmt, args...)
+}
+
+// podName returns Pod name or generateName prefix for controller-created Pods
+func podName(pod *corev1.Pod) string {
+	if pod.Name != "" {
+		return pod.Name
+	}
+	return pod.GenerateName
+}
+
+// podEventTarget returns reference to Pod controller (Pod does not exist during admission); Pod reference, if Pod has no controller
+func podEventTarget(pod *corev1.Pod, namespace string) *corev1.ObjectReference {
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		return &corev1.ObjectReference{APIVersion: owner.APIVersion, Kind: owner.Kind, Name: owner.Name, UID: owner.UID, Namespace: namespace}
+	}
+	return &corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Name: podName(pod), Namespace: namespace}
+}
+
+// record K8s event about Pod on Pod controller; noop for dry run admission requests
+func (mw *mutatingWebhook) podEventf(pod *corev1.Pod, ar *whmodel.AdmissionReview, eventType, reason, messageFmt string, args ...interface{}) {
+	if ar.DryRun {
+		return
+	}
+	mw.eventf(podEventTarget(pod, ar.Namespace), eventType, reason, "pod %s: %s", podName(pod), fmt.Sprintf(messageFmt, args...))
+}
+
+// skip records GTokenSkipped event (with metrics skip reason) and skipped audit status for Pod; returns skipped mutation result
+func (mw *mutatingWebhook) skip(ctx context.Context, pod *corev1.Pod, ar *whmodel.AdmissionReview, reason, messageFmt string, args ...interface{}) mutationResult {
+	setAuditAnnotation(ctx, statusAuditKey, skippedStatus)
+	mw.podEventf(pod, ar, corev1.EventTypeNormal, skippedReason, "skipped AWS role injection (%s): %s", reason, fmt.Sprintf(messageFmt, args...))
+	return skipped(reason)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	cmp "github.com/google/go-cmp/cmp"
+	whmodel "github.com/slok/kubewebhook/v2/pkg/model"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_podEventTarget(t *testing.T) {
+	controller := true
+	tests := []struct {
+		name string
+		pod  *corev1.Pod
+		want *corev1.ObjectReference
+	}{
+		{
+			name: "controller",
+			pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "app-5d8f9-",
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "app-5d8f9", UID: "1234", Controller: &controller},
+				},
+			}},
+			want: &corev1.ObjectReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "app-5d8f9", UID: "1234", Namespace: "test-namespace"},
+		},
+		{
+			name: "bare pod",
+			pod:  &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app"}},
+			want: &corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Name: "app", Namespace: "test-namespace"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := podEventTarget(tt.pod, "test-namespace"); !cmp.Equal(got, tt.want) {
+				t.Errorf("podEventTarget() = diff %v", cmp.Diff(got, tt.want))
+			}
+		})
+	}
+}
+
+func Test_mutatingWebhook_podEventf(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	mw := &mutatingWebhook{recorder: recorder}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{GenerateName: "app-"}}
+	mw.podEventf(pod, &whmodel.AdmissionReview{Namespace: "test-namespace", DryRun: true}, corev1.EventTypeNormal, injectedReason, "injected")
+	mw.podEventf(pod, &whmodel.AdmissionReview{Namespace: "test-namespace"}, corev1.EventTypeNormal, injectedReason, "injected")
+	if got, want := len(recorder.Events), 1; got != want {
+		t.Fatalf("mutatingWebhook.podEventf() recorded %d events, want %d (no events for dry run)", got, want)
+	}
+	if got, want := <-recorder.Events, "Normal GTokenInjected pod app-: injected"; got != want {
+		t.Errorf("mutatingWebhook.podEventf() event = %q, want %q", got, want)
+	}
+	// recorder is optional
+	(&mutatingWebhook{}).podEventf(pod, &whmodel.AdmissionReview{}, corev1.EventTypeNormal, injectedReason, "injected")
+}
+
+//nolint:funlen
+func Test_mutatingWebhook_mutatePod_skipEvents(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		objects     []runtime.Object
+		wantEvent   string
+		wantStatus  string
+	}{
+		{
+			name:        "opt-out",
+			annotations: map[string]string{injectAnnotationKey: "false"},
+			wantEvent:   "Normal GTokenSkipped pod test-pod: skipped AWS role injection (opt-out): gtoken.doit-intl.com/inject annotation is set to false",
+			wantStatus:  skippedStatus,
+		},
+		{
+			name:        "already injected",
+			annotations: map[string]string{statusAnnotationKey: injectedStatus},
+			wantEvent:   "Normal GTokenSkipped pod test-pod: skipped AWS role injection (already-injected): pod is already injected",
+			wantStatus:  skippedStatus,
+		},
+		{
+			name:       "service account not found",
+			wantEvent:  "Normal GTokenSkipped pod test-pod: skipped AWS role injection (no-role): service account test-sa not found",
+			wantStatus: skippedStatus,
+		},
+		{
+			name:        "no containers",
+			annotations: map[string]string{containersAnnotationKey: "missing"},
+			objects:     []runtime.Object{newServiceAccount("test-sa", "arn:aws:iam::123456789012:role/app")},
+			wantEvent:   "Normal GTokenSkipped pod test-pod: skipped AWS role injection (no-containers): no containers targeted for AWS role injection",
+			wantStatus:  skippedStatus,
+		},
+		{
+			name:    "service account without role",
+			objects: []runtime.Object{&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "test-sa", Namespace: "test-namespace"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Annotations: tt.annotations},
+				Spec: corev1.PodSpec{
+					Containers:         []corev1.Container{{Name: "app"}},
+					ServiceAccountName: "test-sa",
+				},
+			}
+			recorder := record.NewFakeRecorder(10)
+			mw := &mutatingWebhook{
+				k8sClient:  fake.NewSimpleClientset(tt.objects...),
+				volumeName: tokenVolumeName,
+				volumePath: tokenVolumePath,
+				tokenFile:  tokenFileName,
+				recorder:   recorder,
+			}
+			annotations := &auditAnnotations{values: make(map[string]string)}
+			ctx := context.WithValue(context.TODO(), auditAnnotationsKey{}, annotations)
+			if _, err := mw.mutatePod(ctx, pod, &whmodel.AdmissionReview{Namespace: "test-namespace"}); err != nil {
+				t.Fatalf("mutatingWebhook.mutatePod() error = %v", err)
+			}
+			var event string
+			select {
+			case event = <-recorder.Events:
+			default:
+			}
+			if event != tt.wantEvent {
+				t.Errorf("mutatingWebhook.mutatePod() event = %q, want %q", event, tt.wantEvent)
+			}
+			if got := annotations.values[statusAuditKey]; got != tt.wantStatus {
+				t.Errorf("mutatingWebhook.mutatePod() status audit annotation = %q, want %q", got, tt.wantStatus)
+			}
+		})
+	}
+}
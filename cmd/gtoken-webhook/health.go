@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// default delay between SIGTERM and server shutdown, to let API server and Service endpoints stop sending requests
+	defaultShutdownDrainDelay = 5 * time.Second
+	// default timeout to wait for in-flight requests on server shutdown
+	defaultShutdownTimeout = 20 * time.Second
+)
+
+// readinessCheck is named readiness condition
+type readinessCheck struct {
+	name  string
+	ready func() bool
+}
+
+// readiness reports webhook is ready to serve admission requests: all checks pass and webhook is not shutting down
+type readiness struct {
+	shuttingDown atomic.Bool
+	checks       []readinessCheck
+}
+
+// addCheck adds named readiness condition
+func (r *readiness) addCheck(name string, ready func() bool) {
+	r.checks = append(r.checks, readinessCheck{name: name, ready: ready})
+}
+
+func (r *readiness) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	if r.shuttingDown.Load() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	for _, check := range r.checks {
+		if !check.ready() {
+			http.Error(w, fmt.Sprintf("%s: not ready", check.name), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// shutdown reports not ready, waits for drain delay and gracefully shuts down server, waiting for in-flight requests
+func (r *readiness) shutdown(server *http.Server, drainDelay, timeout time.Duration) {
+	logger.Info("shutting down webhook server")
+	r.shuttingDown.Store(true)
+	time.Sleep(drainDelay)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.WithError(err).Error("error shutting down webhook server")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_readiness_ServeHTTP(t *testing.T) {
+	tests := []struct {
+		name         string
+		checks       []bool
+		shuttingDown bool
+		want         int
+	}{
+		{name: "no checks", want: http.StatusOK},
+		{name: "all ready", checks: []bool{true, true}, want: http.StatusOK},
+		{name: "not ready", checks: []bool{true, false}, want: http.StatusServiceUnavailable},
+		{name: "shutting down", checks: []bool{true}, shuttingDown: true, want: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &readiness{}
+			for _, ready := range tt.checks {
+				ready := ready
+				r.addCheck("test", func() bool { return ready })
+			}
+			r.shuttingDown.Store(tt.shuttingDown)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if w.Code != tt.want {
+				t.Errorf("readiness.ServeHTTP() status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func Test_readiness_shutdown(t *testing.T) {
+	r := &readiness{}
+	server := httptest.NewServer(http.NewServeMux())
+	r.shutdown(server.Config, 0, defaultShutdownTimeout)
+	if !r.shuttingDown.Load() {
+		t.Error("readiness.shutdown() did not report shutting down")
+	}
+	if _, err := http.Get(server.URL); err == nil {
+		t.Error("readiness.shutdown() server still serves requests")
+	}
+}
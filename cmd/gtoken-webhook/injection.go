@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// Pod annotation key; comma separated list of containers to inject into (all containers, if not specified)
+	containersAnnotationKey = "gtoken.doit-intl.com/containers"
+	// Pod annotation key; comma separated list of containers to exclude from injection
+	excludeContainersAnnotationKey = "gtoken.doit-intl.com/exclude-containers"
+	// Pod annotation key prefix; used to set container AWS Role ARN: gtoken.doit-intl.com/role-arn.<container>
+	containerRoleArnAnnotationPrefix = roleArnAnnotationKey + "."
+)
+
+// podInjection holds Pod injection settings resolved from Pod and Service Account annotations
+type podInjection struct {
+	// Pod AWS Role ARN
+	roleArn string
+	// per-container AWS Role ARN
+	containerRoleArns map[string]string
+	// containers to inject into (nil for all containers)
+	include map[string]bool
+	// containers to exclude from injection
+	exclude map[string]bool
+	// AWS role session name
+	sessionName string
+	// AWS region and STS endpoint settings
+	awsConfig awsConfig
+	// gtoken containers injection mode
+	mode injectionMode
+	// ID token audience (gtoken default, if empty)
+	audience string
+	// gtoken containers resources
+	resources corev1.ResourceRequirements
+}
+
+// parseContainerList parses comma separated list of container names
+func parseContainerList(value string) map[string]bool {
+	names := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// newPodInjection creates Pod injection settings from Pod annotations
+func newPodInjection(pod *corev1.Pod, roleArn string, podRoleArnOverride bool) *podInjection {
+	injection := &podInjection{roleArn: roleArn, containerRoleArns: make(map[string]string)}
+	annotations := pod.GetAnnotations()
+	if value, ok := annotations[containersAnnotationKey]; ok {
+		injection.include = parseContainerList(value)
+	}
+	injection.exclude = parseContainerList(annotations[excludeContainersAnnotationKey])
+	for key, value := range annotations {
+		if !strings.HasPrefix(key, containerRoleArnAnnotationPrefix) {
+			continue
+		}
+		if !podRoleArnOverride {
+			logger.WithField("pod", pod.Name).Warnf("ignoring %s annotation: Pod AWS Role ARN override is disabled", key)
+			continue
+		}
+		injection.containerRoleArns[strings.TrimPrefix(key, containerRoleArnAnnotationPrefix)] = value
+	}
+	return injection
+}
+
+// empty returns true if there is no AWS Role ARN to inject
+func (p *podInjection) empty() bool {
+	return p.roleArn == "" && len(p.containerRoleArns) == 0
+}
+
+// containerRoleArn returns AWS Role ARN to inject into the container; false if container should be skipped
+func (p *podInjection) containerRoleArn(name string) (string, bool) {
+	if p.exclude[name] || (p.include != nil && !p.include[name]) {
+		return "", false
+	}
+	if roleArn, ok := p.containerRoleArns[name]; ok {
+		return roleArn, true
+	}
+	return p.roleArn, p.roleArn != ""
+}
+
+// injected returns true if Pod was already injected by webhook (reinvocation, retry or another replica)
+func (mw *mutatingWebhook) injected(pod *corev1.Pod) bool {
+	return pod.GetAnnotations()[statusAnnotationKey] == injectedStatus || mw.hasTokenInitContainer(pod)
+}
+
+// hasTokenInitContainer returns true if Pod has gtoken init container mounting the token volume; unlike the
+// status annotation, set by webhook but writable by Pod author, it verifies the injection took place
+func (mw *mutatingWebhook) hasTokenInitContainer(pod *corev1.Pod) bool {
+	volume := false
+	for _, v := range pod.Spec.Volumes {
+		if v.Name == mw.volumeName {
+			volume = true
+			break
+		}
+	}
+	if !volume {
+		return false
+	}
+	for _, container := range pod.Spec.InitContainers {
+		if container.Name != initContainerName {
+			continue
+		}
+		for _, mount := range container.VolumeMounts {
+			if mount.Name == mw.volumeName && mount.MountPath == mw.volumePath {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkCollisions returns error if Pod already has volume, containers or mounts with names used by webhook
+func (mw *mutatingWebhook) checkCollisions(pod *corev1.Pod, injection *podInjection) error {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name == mw.volumeName {
+			return fmt.Errorf("pod volume %q already exists", volume.Name)
+		}
+	}
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		if container.Name == initContainerName || container.Name == sidekickContainerName {
+			return fmt.Errorf("pod container %q already exists", container.Name)
+		}
+		if _, ok := injection.containerRoleArn(container.Name); !ok {
+			continue
+		}
+		for _, mount := range container.VolumeMounts {
+			if mount.Name == mw.volumeName || mount.MountPath == mw.volumePath {
+				return fmt.Errorf("container %q already mounts %q at %q", container.Name, mount.Name, mount.MountPath)
+			}
+		}
+	}
+	return nil
+}
+
+// setAwsEnv adds AWS environment variables to container env; variables already set by user are kept, unless override is enabled
+func (mw *mutatingWebhook) setAwsEnv(container string, env, awsEnv []corev1.EnvVar) []corev1.EnvVar {
+	for _, awsVar := range awsEnv {
+		index := -1
+		for i, v := range env {
+			if v.Name == awsVar.Name {
+				index = i
+				break
+			}
+		}
+		switch {
+		case index == -1:
+			env = append(env, awsVar)
+		case mw.overrideAwsEnv:
+			env[index] = awsVar
+		default:
+			logger.WithField("container", container).Debugf("keeping %s environment variable set in container", awsVar.Name)
+		}
+	}
+	return env
+}
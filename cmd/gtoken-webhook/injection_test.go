@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	cmp "github.com/google/go-cmp/cmp"
+	whmodel "github.com/slok/kubewebhook/v2/pkg/model"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+// containerRoleArns returns AWS_ROLE_ARN env var per container
+func containerRoleArns(containers []corev1.Container) map[string]string {
+	roles := make(map[string]string)
+	for _, container := range containers {
+		for _, env := range container.Env {
+			if env.Name == awsRoleArn {
+				roles[container.Name] = env.Value
+			}
+		}
+	}
+	return roles
+}
+
+//nolint:funlen
+func Test_mutatingWebhook_mutatePod_containers(t *testing.T) {
+	const (
+		saRoleArn  = "arn:aws:iam::123456789012:role/sa-role"
+		appRoleArn = "arn:aws:iam::123456789012:role/app-role"
+	)
+	tests := []struct {
+		name               string
+		annotations        map[string]string
+		saRoleArn          string
+		podRoleArnOverride bool
+		want               map[string]string
+		wantInjected       bool
+	}{
+		{
+			name:         "inject into all containers",
+			saRoleArn:    saRoleArn,
+			want:         map[string]string{"init": saRoleArn, "app": saRoleArn, "worker": saRoleArn, "istio-proxy": saRoleArn},
+			wantInjected: true,
+		},
+		{
+			name:         "inject into listed containers",
+			annotations:  map[string]string{containersAnnotationKey: "app, worker"},
+			saRoleArn:    saRoleArn,
+			want:         map[string]string{"app": saRoleArn, "worker": saRoleArn},
+			wantInjected: true,
+		},
+		{
+			name:         "exclude containers",
+			annotations:  map[string]string{excludeContainersAnnotationKey: "istio-proxy,init"},
+			saRoleArn:    saRoleArn,
+			want:         map[string]string{"app": saRoleArn, "worker": saRoleArn},
+			wantInjected: true,
+		},
+		{
+			name: "per-container role",
+			annotations: map[string]string{
+				containersAnnotationKey:                  "app,worker",
+				containerRoleArnAnnotationPrefix + "app": appRoleArn,
+			},
+			saRoleArn:          saRoleArn,
+			podRoleArnOverride: true,
+			want:               map[string]string{"app": appRoleArn, "worker": saRoleArn},
+			wantInjected:       true,
+		},
+		{
+			name:               "per-container role without Service Account role",
+			annotations:        map[string]string{containerRoleArnAnnotationPrefix + "app": appRoleArn},
+			podRoleArnOverride: true,
+			want:               map[string]string{"app": appRoleArn},
+			wantInjected:       true,
+		},
+		{
+			name:        "per-container role override disabled",
+			annotations: map[string]string{containerRoleArnAnnotationPrefix + "app": appRoleArn},
+			want:        map[string]string{},
+		},
+		{
+			name:        "no targeted containers",
+			annotations: map[string]string{containersAnnotationKey: "missing"},
+			saRoleArn:   saRoleArn,
+			want:        map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "test-sa", Namespace: "test-namespace"}}
+			if tt.saRoleArn != "" {
+				sa.Annotations = map[string]string{awsRoleArnKey: tt.saRoleArn}
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Annotations: tt.annotations},
+				Spec: corev1.PodSpec{
+					InitContainers:     []corev1.Container{{Name: "init"}},
+					Containers:         []corev1.Container{{Name: "app"}, {Name: "worker"}, {Name: "istio-proxy"}},
+					ServiceAccountName: "test-sa",
+				},
+			}
+			mw := &mutatingWebhook{
+				k8sClient:          fake.NewSimpleClientset(sa),
+				volumeName:         "test-volume-name",
+				volumePath:         "/test-volume-path",
+				tokenFile:          "test-token",
+				podRoleArnOverride: tt.podRoleArnOverride,
+			}
+			if _, err := mw.mutatePod(context.TODO(), pod, &whmodel.AdmissionReview{Namespace: "test-namespace"}); err != nil {
+				t.Fatalf("mutatingWebhook.mutatePod() error = %v", err)
+			}
+			got := containerRoleArns(append(pod.Spec.InitContainers, pod.Spec.Containers...))
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("mutatingWebhook.mutatePod() = diff %v", cmp.Diff(got, tt.want))
+			}
+			if injected := len(pod.Spec.Volumes) > 0; injected != tt.wantInjected {
+				t.Errorf("mutatingWebhook.mutatePod() injected = %v, want %v", injected, tt.wantInjected)
+			}
+		})
+	}
+}
+
+func Test_mutatingWebhook_mutatePod_idempotent(t *testing.T) {
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+		Name:        "test-sa",
+		Namespace:   "test-namespace",
+		Annotations: map[string]string{awsRoleArnKey: "arn:aws:iam::123456789012:role/sa-role"},
+	}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pod"},
+		Spec: corev1.PodSpec{
+			Containers:         []corev1.Container{{Name: "app"}},
+			ServiceAccountName: "test-sa",
+		},
+	}
+	mw := &mutatingWebhook{
+		k8sClient:  fake.NewSimpleClientset(sa),
+		volumeName: tokenVolumeName,
+		volumePath: tokenVolumePath,
+		tokenFile:  tokenFileName,
+	}
+	ar := &whmodel.AdmissionReview{Namespace: "test-namespace"}
+	if _, err := mw.mutatePod(context.TODO(), pod, ar); err != nil {
+		t.Fatalf("mutatingWebhook.mutatePod() error = %v", err)
+	}
+	injected := pod.DeepCopy()
+	if _, err := mw.mutatePod(context.TODO(), pod, ar); err != nil {
+		t.Fatalf("mutatingWebhook.mutatePod() second call error = %v", err)
+	}
+	if !cmp.Equal(pod, injected) {
+		t.Errorf("mutatingWebhook.mutatePod() is not idempotent: diff %v", cmp.Diff(pod, injected))
+	}
+	// injected Pod without status annotation (e.g. annotation removed by user)
+	delete(pod.Annotations, statusAnnotationKey)
+	if _, err := mw.mutatePod(context.TODO(), pod, ar); err != nil {
+		t.Fatalf("mutatingWebhook.mutatePod() without status annotation error = %v", err)
+	}
+	if len(pod.Spec.Containers) != len(injected.Spec.Containers) || len(pod.Spec.Volumes) != len(injected.Spec.Volumes) {
+		t.Errorf("mutatingWebhook.mutatePod() injected Pod twice")
+	}
+}
+
+//nolint:funlen
+func Test_mutatingWebhook_mutatePod_existingConfig(t *testing.T) {
+	const (
+		saRoleArn   = "arn:aws:iam::123456789012:role/sa-role"
+		userRoleArn = "arn:aws:iam::123456789012:role/user-role"
+	)
+	tests := []struct {
+		name           string
+		spec           corev1.PodSpec
+		overrideAwsEnv bool
+		wantRoleArn    map[string]string
+		wantErr        bool
+	}{
+		{
+			name:        "keep user AWS env",
+			spec:        corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Env: []corev1.EnvVar{{Name: awsRoleArn, Value: userRoleArn}}}}},
+			wantRoleArn: map[string]string{"app": userRoleArn},
+		},
+		{
+			name:           "override user AWS env",
+			spec:           corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Env: []corev1.EnvVar{{Name: awsRoleArn, Value: userRoleArn}}}}},
+			overrideAwsEnv: true,
+			wantRoleArn:    map[string]string{"app": saRoleArn},
+		},
+		{
+			name: "volume name collision",
+			spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app"}},
+				Volumes:    []corev1.Volume{{Name: tokenVolumeName}},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "container name collision",
+			spec:    corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}, {Name: sidekickContainerName}}},
+			wantErr: true,
+		},
+		{
+			name: "mount path collision",
+			spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name:         "app",
+				VolumeMounts: []corev1.VolumeMount{{Name: "aws-token", MountPath: tokenVolumePath}},
+			}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-sa",
+				Namespace:   "test-namespace",
+				Annotations: map[string]string{awsRoleArnKey: saRoleArn},
+			}}
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod"}, Spec: tt.spec}
+			pod.Spec.ServiceAccountName = "test-sa"
+			mw := &mutatingWebhook{
+				k8sClient:      fake.NewSimpleClientset(sa),
+				volumeName:     tokenVolumeName,
+				volumePath:     tokenVolumePath,
+				tokenFile:      tokenFileName,
+				overrideAwsEnv: tt.overrideAwsEnv,
+			}
+			_, err := mw.mutatePod(context.TODO(), pod, &whmodel.AdmissionReview{Namespace: "test-namespace"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("mutatingWebhook.mutatePod() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := containerRoleArns(pod.Spec.Containers[:1]); !cmp.Equal(got, tt.wantRoleArn) {
+				t.Errorf("mutatingWebhook.mutatePod() = diff %v", cmp.Diff(got, tt.wantRoleArn))
+			}
+			if got := len(pod.Spec.Containers[0].Env); got != 3 {
+				t.Errorf("mutatingWebhook.mutatePod() env vars = %d, want 3", got)
+			}
+		})
+	}
+}
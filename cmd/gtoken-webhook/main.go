@@ -3,11 +3,12 @@ package main
 import (
 	"context"
 	"fmt"
-	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
-	"strings"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
@@ -20,11 +21,14 @@ import (
 	whmodel "github.com/slok/kubewebhook/v2/pkg/model"
 	wh "github.com/slok/kubewebhook/v2/pkg/webhook"
 	"github.com/slok/kubewebhook/v2/pkg/webhook/mutating"
+	"github.com/slok/kubewebhook/v2/pkg/webhook/validating"
 	"github.com/urfave/cli"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	kubernetesConfig "sigs.k8s.io/controller-runtime/pkg/client/config"
 )
 
@@ -42,9 +46,25 @@ const (
 	// token file name
 	tokenFileName = "gtoken"
 
+	// Service Account used by Pods without explicit serviceAccountName
+	defaultServiceAccountName = "default"
+
 	// AWS annotation key; used to annotate Kubernetes Service Account with AWS Role ARN
 	awsRoleArnKey = "amazonaws.com/role-arn"
 
+	// Pod annotation key; set to "false" to skip injection
+	injectAnnotationKey = "gtoken.doit-intl.com/inject"
+	// Pod annotation key; used to override Service Account AWS Role ARN (Namespace annotation: default AWS Role ARN)
+	roleArnAnnotationKey = "gtoken.doit-intl.com/role-arn"
+	// Pod annotation key; set by webhook on injected Pods
+	statusAnnotationKey = "gtoken.doit-intl.com/status"
+	// status annotation value
+	injectedStatus = "injected"
+
+	// gtoken init and sidekick container names
+	initContainerName     = "generate-gcp-id-token"
+	sidekickContainerName = "update-gcp-id-token"
+
 	// AWS Web Identity Token ENV
 	awsWebIdentityTokenFile = "AWS_WEB_IDENTITY_TOKEN_FILE"
 	awsRoleArn              = "AWS_ROLE_ARN"
@@ -60,50 +80,60 @@ var (
 	testMode = false
 )
 
-const (
-	requestsCPU    = "5m"
-	requestsMemory = "10Mi"
-	limitsCPU      = "20m"
-	limitsMemory   = "50Mi"
-)
-
 type mutatingWebhook struct {
-	k8sClient  kubernetes.Interface
-	image      string
-	pullPolicy string
-	volumeName string
-	volumePath string
-	tokenFile  string
+	k8sClient       kubernetes.Interface
+	image           string
+	pullPolicy      string
+	volumeName      string
+	volumePath      string
+	tokenFile       string
+	waitForMetadata time.Duration
+	// informer cache of Service Accounts and Namespaces (live GET, if nil)
+	cache *k8sCache
+	// GTokenBinding watcher (GTokenBinding support is disabled, if nil)
+	bindings *bindingWatcher
+	// AWS Role ARN template (disabled, if nil)
+	roleArnTemplate *roleArnTemplate
+	// AWS role authorization policy (all AWS roles are allowed, if nil)
+	policy *policy
+	// K8s event recorder (events are not recorded, if nil)
+	recorder record.EventRecorder
+	// allow Pod annotation to override Service Account AWS Role ARN
+	podRoleArnOverride bool
+	// AWS role session name generator
+	sessionNamer *sessionNamer
+	// override AWS environment variables already set in Pod containers
+	overrideAwsEnv bool
+	// default injection mode
+	injectionMode injectionMode
+	// cluster supports native sidecar containers
+	nativeSidecars bool
+	// default AWS region and STS endpoint settings
+	awsConfig awsConfig
+	// default gtoken containers resources
+	resources corev1.ResourceRequirements
+	// gtoken containers security context (not set, if nil)
+	securityContext *corev1.SecurityContext
 }
 
 var logger *log.Logger
 
-// Returns an int >= min, < max
-func randomInt(min, max int) int {
-	//nolint:gosec
-	return min + rand.Intn(max-min)
-}
-
-// Generate a random string of a-z chars with len = l
-func randomString(l int) string {
-	if testMode {
-		return strings.Repeat("0", 16)
-	}
-	rand.Seed(time.Now().UnixNano())
-	bytes := make([]byte, l)
-	for i := 0; i < l; i++ {
-		bytes[i] = byte(randomInt(97, 122))
+func newK8SClient() (kubernetes.Interface, error) {
+	kubeConfig, err := kubernetesConfig.GetConfig()
+	if err != nil {
+		return nil, err
 	}
-	return string(bytes)
+
+	return kubernetes.NewForConfig(kubeConfig)
 }
 
-func newK8SClient() (kubernetes.Interface, error) {
+func newDynamicClient() (dynamic.Interface, error) {
 	kubeConfig, err := kubernetesConfig.GetConfig()
 	if err != nil {
 		return nil, err
 	}
 
-	return kubernetes.NewForConfig(kubeConfig)
+	return dynamic.NewForConfig(kubeConfig)
 }
 
 func healthzHandler(w http.ResponseWriter, r *http.Request) {
@@ -121,12 +151,7 @@ func serveMetrics(addr string) {
 	}
 }
 
-func handlerFor(config mutating.WebhookConfig, recorder wh.MetricsRecorder, logger *log.Logger) http.Handler {
-	webhook, err := mutating.NewWebhook(config)
-	if err != nil {
-		logger.WithError(err).Fatal("error creating webhook")
-	}
-
+func handlerFor(webhook wh.Webhook, recorder wh.MetricsRecorder, logger *log.Logger) http.Handler {
 	measuredWebhook := wh.NewMeasuredWebhook(recorder, webhook)
 
 	handler, err := whhttp.HandlerFor(whhttp.HandlerConfig{
@@ -137,25 +162,46 @@ func handlerFor(config mutating.WebhookConfig, recorder wh.MetricsRecorder, logg
 		logger.WithError(err).Fatalf("error creating webhook")
 	}
 
-	return handler
+	return withAuditAnnotations(handler)
 }
 
-// check if K8s Service Account is annotated with AWS role
-func (mw *mutatingWebhook) getAwsRoleArn(ctx context.Context, name, ns string) (string, bool, error) {
-	sa, err := mw.k8sClient.CoreV1().ServiceAccounts(ns).Get(ctx, name, metav1.GetOptions{})
+// get K8s Service Account; returns empty Service Account and warning, if Service Account does not exist (yet)
+func (mw *mutatingWebhook) getServiceAccount(ctx context.Context, name, ns string) (*corev1.ServiceAccount, string, error) {
+	// Pods without explicit Service Account run as default Service Account
+	if name == "" {
+		name = defaultServiceAccountName
+	}
+	var sa *corev1.ServiceAccount
+	var err error
+	// lookup source: cache or api (cache miss falls back to live GET)
+	source := apiSource
+	start := time.Now()
+	if mw.cache != nil {
+		sa, source, err = mw.cache.getServiceAccount(ctx, name, ns)
+	} else {
+		sa, err = mw.k8sClient.CoreV1().ServiceAccounts(ns).Get(ctx, name, metav1.GetOptions{})
+	}
+	serviceAccountLookupDuration.WithLabelValues(source).Observe(time.Since(start).Seconds())
+	if k8serrors.IsNotFound(err) {
+		logger.WithFields(log.Fields{"service account": name, "namespace": ns}).Warn("service account not found")
+		return &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns}}, fmt.Sprintf("gtoken: service account %s/%s not found, service account AWS role is not injected", ns, name), nil
+	}
 	if err != nil {
-		logger.WithFields(log.Fields{"service account": name, "namespace": ns}).WithError(err).Fatalf("error getting service account")
-		return "", false, err
+		logger.WithFields(log.Fields{"service account": name, "namespace": ns}).WithError(err).Error("error getting service account")
+		return nil, "", errors.Wrapf(err, "failed to get service account %s/%s", ns, name)
 	}
-	roleArn, ok := sa.GetAnnotations()[awsRoleArnKey]
-	return roleArn, ok, nil
+	return sa, "", nil
 }
 
-func (mw *mutatingWebhook) mutateContainers(containers []corev1.Container, roleArn string) bool {
-	if len(containers) == 0 {
-		return false
-	}
+func (mw *mutatingWebhook) mutateContainers(containers []corev1.Container, injection *podInjection) bool {
+	var mutated bool
 	for i, container := range containers {
+		// skip containers not targeted by Pod annotations
+		roleArn, ok := injection.containerRoleArn(container.Name)
+		if !ok {
+			logger.WithField("container", container.Name).Debug("skipping container")
+			continue
+		}
 		// add token volume mount
 		container.VolumeMounts = append(container.VolumeMounts, []corev1.VolumeMount{
 			{
@@ -164,7 +210,7 @@ func (mw *mutatingWebhook) mutateContainers(containers []corev1.Container, roleA
 			},
 		}...)
 		// add AWS Web Identity Token environment variables to container
-		container.Env = append(container.Env, []corev1.EnvVar{
+		container.Env = mw.setAwsEnv(container.Name, container.Env, append([]corev1.EnvVar{
 			{
 				Name:  awsWebIdentityTokenFile,
 				Value: fmt.Sprintf("%s/%s", mw.volumePath, mw.tokenFile),
@@ -175,55 +221,167 @@ func (mw *mutatingWebhook) mutateContainers(containers []corev1.Container, roleA
 			},
 			{
 				Name:  awsRoleSessionName,
-				Value: fmt.Sprintf("gtoken-webhook-%s", randomString(16)),
+				Value: injection.sessionName,
 			},
-		}...)
+		}, injection.awsConfig.env()...))
 		// update containers
 		containers[i] = container
+		mutated = true
 	}
-	return true
+	return mutated
 }
 
-func (mw *mutatingWebhook) mutatePod(ctx context.Context, pod *corev1.Pod, ns string, dryRun bool) error {
-	// get service account AWS Role ARN annotation
-	roleArn, ok, err := mw.getAwsRoleArn(ctx, pod.Spec.ServiceAccountName, ns)
+// check if Pod is opted out of injection with annotation
+func skipPod(pod *corev1.Pod) bool {
+	value, ok := pod.GetAnnotations()[injectAnnotationKey]
+	if !ok {
+		return false
+	}
+	inject, err := strconv.ParseBool(value)
 	if err != nil {
-		return err
+		logger.WithField("value", value).Warnf("ignoring invalid %s annotation", injectAnnotationKey)
+		return false
 	}
-	if !ok {
+	return !inject
+}
+
+// get AWS Role ARN from Pod annotation (if allowed), GTokenBinding, Service Account or Namespace annotation
+func (mw *mutatingWebhook) getPodAwsRoleArn(pod *corev1.Pod, sa *corev1.ServiceAccount, binding *gtokenBinding, namespace *corev1.Namespace) string {
+	if roleArn, ok := pod.GetAnnotations()[roleArnAnnotationKey]; ok {
+		if mw.podRoleArnOverride {
+			return roleArn
+		}
+		logger.WithField("pod", pod.Name).Warnf("ignoring %s annotation: Pod AWS Role ARN override is disabled", roleArnAnnotationKey)
+	}
+	if binding != nil {
+		return binding.Spec.RoleArn
+	}
+	if roleArn, ok := sa.GetAnnotations()[awsRoleArnKey]; ok {
+		return roleArn
+	}
+	// Namespace default AWS Role ARN
+	return namespace.GetAnnotations()[roleArnAnnotationKey]
+}
+
+func (mw *mutatingWebhook) mutatePod(ctx context.Context, pod *corev1.Pod, ar *whmodel.AdmissionReview) ([]string, error) {
+	warnings, result, err := mw.injectPod(ctx, pod, ar)
+	if err != nil {
+		result = mutationResult{outcome: errorOutcome}
+	}
+	recordMutation(ar.Namespace, result)
+	return warnings, err
+}
+
+// injectPod injects AWS credentials into Pod; returns admission warnings and mutation result
+func (mw *mutatingWebhook) injectPod(ctx context.Context, pod *corev1.Pod, ar *whmodel.AdmissionReview) ([]string, mutationResult, error) {
+	var warnings []string
+	if skipPod(pod) {
+		logger.Debugf("skipping pods with %s annotation set to false", injectAnnotationKey)
+		return warnings, mw.skip(ctx, pod, ar, optOutSkipReason, "%s annotation is set to false", injectAnnotationKey), nil
+	}
+	if mw.injected(pod) {
+		logger.Debug("skipping already injected pod")
+		return warnings, mw.skip(ctx, pod, ar, alreadyInjectedSkipReason, "pod is already injected"), nil
+	}
+	sa, warning, err := mw.getServiceAccount(ctx, pod.Spec.ServiceAccountName, ar.Namespace)
+	if err != nil {
+		return warnings, mutationResult{}, err
+	}
+	if warning != "" {
+		warnings = append(warnings, warning)
+	}
+	namespace, err := mw.getNamespace(ctx, ar.Namespace)
+	if err != nil {
+		return warnings, mutationResult{}, err
+	}
+	binding, err := mw.getBinding(pod, sa, ar.Namespace)
+	if err != nil {
+		return warnings, mutationResult{}, errors.Wrap(err, "failed to get GTokenBinding")
+	}
+	// get Pod, GTokenBinding, Service Account or Namespace AWS Role ARN
+	roleArn := mw.getPodAwsRoleArn(pod, sa, binding, namespace)
+	if roleArn == "" {
+		// derive AWS Role ARN from naming convention
+		roleArn = mw.deriveRoleArn(sa, namespace)
+	}
+	injection := newPodInjection(pod, roleArn, mw.podRoleArnOverride)
+	if injection.empty() {
 		logger.Debug("skipping pods with Service Account without AWS Role ARN annotation")
-		return nil
+		if warning != "" {
+			// Service Account AWS role is expected, but Service Account was not found
+			return warnings, mw.skip(ctx, pod, ar, noRoleSkipReason, "service account %s not found", sa.Name), nil
+		}
+		return warnings, skipped(noRoleSkipReason), nil
+	}
+	// check AWS roles against authorization policy
+	if err := mw.checkPolicy(injection, sa, namespace); err != nil {
+		logger.WithFields(log.Fields{"pod": pod.Name, "namespace": ar.Namespace}).WithError(err).Warn("AWS role is not allowed by policy")
+		mw.podEventf(pod, ar, corev1.EventTypeWarning, roleNotAllowedReason, "%s (policy action: %s)", err.Error(), mw.policy.Action)
+		warnings = append(warnings, fmt.Sprintf("gtoken: %s, AWS credentials are not injected", err.Error()))
+		if mw.policy.Action == denyAction {
+			setAuditAnnotation(ctx, statusAuditKey, deniedStatus)
+			// mark Pod as denied; validating webhook rejects denied Pods
+			if pod.Annotations == nil {
+				pod.Annotations = make(map[string]string)
+			}
+			pod.Annotations[statusAnnotationKey] = deniedStatus
+			pod.Annotations[deniedReasonAnnotationKey] = err.Error()
+			return warnings, mutationResult{outcome: deniedOutcome, reason: notAllowedSkipReason}, nil
+		}
+		setAuditAnnotation(ctx, statusAuditKey, skippedStatus)
+		return warnings, skipped(notAllowedSkipReason), nil
+	}
+	// get settings with precedence: Pod > GTokenBinding > Service Account > Namespace > webhook defaults
+	injection.awsConfig = mw.awsConfig.merge(namespace.GetAnnotations()).merge(sa.GetAnnotations()).mergeBinding(binding).merge(pod.GetAnnotations())
+	injection.mode = mw.podInjectionMode(pod, sa, binding, namespace)
+	injection.audience = podAudience(pod, sa, binding, namespace)
+	injection.resources = podResources(pod, mw.resources)
+	// generate AWS role session name
+	namer := mw.sessionNamer
+	if namer == nil {
+		namer = defaultSessionNamer
+	}
+	injection.sessionName = namer.name(pod, ar)
+	// fail on volume and container name collisions
+	if err := mw.checkCollisions(pod, injection); err != nil {
+		return warnings, mutationResult{}, err
 	}
 	// mutate Pod init containers
-	initContainersMutated := mw.mutateContainers(pod.Spec.InitContainers, roleArn)
+	initContainersMutated := mw.mutateContainers(pod.Spec.InitContainers, injection)
 	if initContainersMutated {
 		logger.Debug("successfully mutated pod init containers")
 	} else {
 		logger.Debug("no pod init containers were mutated")
 	}
 	// mutate Pod containers
-	containersMutated := mw.mutateContainers(pod.Spec.Containers, roleArn)
+	containersMutated := mw.mutateContainers(pod.Spec.Containers, injection)
 	if containersMutated {
 		logger.Debug("successfully mutated pod containers")
 	} else {
 		logger.Debug("no pod containers were mutated")
 	}
 
-	if (initContainersMutated || containersMutated) && !dryRun {
-		// prepend gtoken init container (as first in it container)
-		pod.Spec.InitContainers = append([]corev1.Container{getGtokenContainer("generate-gcp-id-token",
-			mw.image, mw.pullPolicy, mw.volumeName, mw.volumePath, mw.tokenFile, false)}, pod.Spec.InitContainers...)
-		logger.Debug("successfully prepended pod init containers to spec")
-		// append sidekick gtoken update container (as last container)
-		pod.Spec.Containers = append(pod.Spec.Containers, getGtokenContainer("update-gcp-id-token",
-			mw.image, mw.pullPolicy, mw.volumeName, mw.volumePath, mw.tokenFile, true))
-		logger.Debug("successfully prepended pod sidekick containers to spec")
+	if !initContainersMutated && !containersMutated {
+		return warnings, mw.skip(ctx, pod, ar, noContainersSkipReason, "no containers targeted for AWS role injection"), nil
+	}
+	setAuditAnnotation(ctx, roleArnAuditKey, injection.roleArn)
+	setAuditAnnotation(ctx, injectionModeAuditKey, string(injection.mode))
+	setAuditAnnotation(ctx, statusAuditKey, injectedStatus)
+	mw.podEventf(pod, ar, corev1.EventTypeNormal, injectedReason, "injected AWS role %s (%s mode)", injection.roleArn, injection.mode)
+
+	if !ar.DryRun {
+		mw.injectGtokenContainers(pod, injection)
 		// append empty gtoken volume
 		pod.Spec.Volumes = append(pod.Spec.Volumes, getGtokenVolume(mw.volumeName))
 		logger.Debug("successfully appended pod spec volumes")
+		// mark Pod as injected
+		if pod.Annotations == nil {
+			pod.Annotations = make(map[string]string)
+		}
+		pod.Annotations[statusAnnotationKey] = injectedStatus
 	}
 
-	return nil
+	return warnings, mutationResult{outcome: injectedOutcome, mode: injection.mode}, nil
 }
 
 func getGtokenVolume(volumeName string) corev1.Volume {
@@ -237,29 +395,30 @@ func getGtokenVolume(volumeName string) corev1.Volume {
 	}
 }
 
-func getGtokenContainer(name, image, pullPolicy, volumeName, volumePath, tokenFile string,
-	refresh bool) corev1.Container {
+// get gtoken container; refresh for sidekick container, otherwise init container
+func (mw *mutatingWebhook) getGtokenContainer(name string, injection *podInjection, refresh bool) corev1.Container {
+	command := []string{"/gtoken", fmt.Sprintf("--file=%s/%s", mw.volumePath, mw.tokenFile), fmt.Sprintf("--refresh=%t", refresh)}
+	// custom ID token audience
+	if injection.audience != "" {
+		command = append(command, fmt.Sprintf("--audience=%s", injection.audience))
+	}
+	// wait for GKE metadata server in init container, which may not be ready yet on fresh nodes
+	if !refresh && mw.waitForMetadata > 0 {
+		command = append(command, fmt.Sprintf("--wait-for-metadata=%s", mw.waitForMetadata))
+	}
 	return corev1.Container{
 		Name:            name,
-		Image:           image,
-		ImagePullPolicy: corev1.PullPolicy(pullPolicy),
-		Command:         []string{"/gtoken", fmt.Sprintf("--file=%s/%s", volumePath, tokenFile), fmt.Sprintf("--refresh=%t", refresh)},
+		Image:           mw.image,
+		ImagePullPolicy: corev1.PullPolicy(mw.pullPolicy),
+		Command:         command,
 		VolumeMounts: []corev1.VolumeMount{
 			{
-				Name:      volumeName,
-				MountPath: volumePath,
-			},
-		},
-		Resources: corev1.ResourceRequirements{
-			Requests: corev1.ResourceList{
-				corev1.ResourceCPU:    resource.MustParse(requestsCPU),
-				corev1.ResourceMemory: resource.MustParse(requestsMemory),
-			},
-			Limits: corev1.ResourceList{
-				corev1.ResourceCPU:    resource.MustParse(limitsCPU),
-				corev1.ResourceMemory: resource.MustParse(limitsMemory),
+				Name:      mw.volumeName,
+				MountPath: mw.volumePath,
 			},
 		},
+		Resources:       injection.resources,
+		SecurityContext: mw.securityContext,
 	}
 }
 
@@ -298,11 +457,11 @@ func before(c *cli.Context) error {
 func (mw *mutatingWebhook) podMutator(ctx context.Context, ar *whmodel.AdmissionReview, obj metav1.Object) (*mutating.MutatorResult, error) {
 	switch v := obj.(type) {
 	case *corev1.Pod:
-		err := mw.mutatePod(ctx, v, ar.Namespace, ar.DryRun)
+		warnings, err := mw.mutatePod(ctx, v, ar)
 		if err != nil {
 			return nil, errors.Wrapf(err, "failed to mutate pod: %s", v.Name)
 		}
-		return &mutating.MutatorResult{MutatedObject: v}, nil
+		return &mutating.MutatorResult{MutatedObject: v, Warnings: warnings}, nil
 	default:
 		return &mutating.MutatorResult{}, nil
 	}
@@ -315,13 +474,113 @@ func runWebhook(c *cli.Context) error {
 		logger.WithError(err).Fatal("error creating k8s client")
 	}
 
+	namer, err := newSessionNamer(c.String("session-name-template"), c.Bool("session-name-random-suffix"))
+	if err != nil {
+		logger.WithError(err).Fatal("error parsing session name template")
+	}
+
+	mode, err := parseInjectionMode(c.String("injection-mode"))
+	if err != nil {
+		logger.WithError(err).Fatal("error parsing injection mode")
+	}
+	nativeSidecars, err := nativeSidecarsSupported(k8sClient)
+	if err != nil {
+		logger.WithError(err).Warn("failed to detect native sidecar containers support")
+	}
+
+	// stop on SIGTERM (Pod termination) or interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	if err = checkValidatingWebhooks(ctx, k8sClient, c.String("validating-webhook-config")); err != nil {
+		logger.WithError(err).Error("validating webhook is not scoped: admission of every Pod and Service Account fails, when gtoken-webhook is not available; delete validating webhook configuration")
+	}
+	ready := &readiness{}
+
+	var k8sCache *k8sCache
+	if c.BoolT("informer-cache") {
+		k8sCache = newK8sCache(k8sClient)
+		// webhook is not ready until cache is synced
+		go func() {
+			if !k8sCache.start(ctx.Done()) && ctx.Err() == nil {
+				logger.Fatal("error syncing informer cache")
+			}
+		}()
+		ready.addCheck("informer cache", k8sCache.synced)
+	}
+
+	var bindings *bindingWatcher
+	if c.Bool("gtoken-bindings") {
+		dynamicClient, err := newDynamicClient()
+		if err != nil {
+			logger.WithError(err).Fatal("error creating k8s dynamic client")
+		}
+		bindings, err = newBindingWatcher(dynamicClient)
+		if err != nil {
+			logger.WithError(err).Fatal("error creating GTokenBinding watcher")
+		}
+		go func() {
+			if !bindings.start(ctx.Done()) && ctx.Err() == nil {
+				logger.Fatal("error syncing GTokenBinding cache")
+			}
+		}()
+		ready.addCheck("GTokenBinding cache", bindings.synced)
+	}
+
+	var roleArnTemplate *roleArnTemplate
+	if text := c.String("role-arn-template"); text != "" {
+		roleArnTemplate, err = newRoleArnTemplate(text, c.String("cluster-name"), c.StringSlice("namespace-account"))
+		if err != nil {
+			logger.WithError(err).Fatal("error parsing role ARN template")
+		}
+	}
+
+	var policy *policy
+	if fileName := c.String("policy-file"); fileName != "" {
+		policy, err = loadPolicy(fileName)
+		if err != nil {
+			logger.WithError(err).Fatal("error loading AWS role policy")
+		}
+	}
+
+	resources, err := newResources(c.String("resource-profile"), resourceProfile{
+		requestsCPU:    c.String("cpu-request"),
+		requestsMemory: c.String("memory-request"),
+		limitsCPU:      c.String("cpu-limit"),
+		limitsMemory:   c.String("memory-limit"),
+	})
+	if err != nil {
+		logger.WithError(err).Fatal("error parsing gtoken containers resources")
+	}
+	var securityContext *corev1.SecurityContext
+	if c.BoolT("restricted-security-context") {
+		securityContext = restrictedSecurityContext()
+	}
+
 	webhook := mutatingWebhook{
-		k8sClient:  k8sClient,
-		image:      c.String("image"),
-		pullPolicy: c.String("pull-policy"),
-		volumeName: c.String("volume-name"),
-		volumePath: c.String("volume-path"),
-		tokenFile:  c.String("token-file"),
+		k8sClient:          k8sClient,
+		cache:              k8sCache,
+		bindings:           bindings,
+		roleArnTemplate:    roleArnTemplate,
+		policy:             policy,
+		recorder:           newEventRecorder(k8sClient),
+		image:              c.String("image"),
+		pullPolicy:         c.String("pull-policy"),
+		volumeName:         c.String("volume-name"),
+		volumePath:         c.String("volume-path"),
+		tokenFile:          c.String("token-file"),
+		waitForMetadata:    c.Duration("wait-for-metadata"),
+		podRoleArnOverride: c.Bool("pod-role-arn-override"),
+		sessionNamer:       namer,
+		overrideAwsEnv:     c.Bool("override-aws-env"),
+		injectionMode:      mode,
+		nativeSidecars:     nativeSidecars,
+		awsConfig: awsConfig{
+			region:               c.String("aws-region"),
+			stsRegionalEndpoints: c.Bool("sts-regional-endpoints"),
+			stsEndpoint:          c.String("sts-endpoint"),
+		},
+		resources:       resources,
+		securityContext: securityContext,
 	}
 
 	mutator := mutating.MutatorFunc(webhook.podMutator)
@@ -332,20 +591,45 @@ func runWebhook(c *cli.Context) error {
 		logger.WithError(err).Fatalf("error creating metrics recorder")
 	}
 
-	podHandler := handlerFor(
-		mutating.WebhookConfig{
-			ID:      "init-gtoken-pods",
-			Obj:     &corev1.Pod{},
-			Mutator: mutator,
-			Logger:  whlogrus.NewLogrus(log.NewEntry(logger)),
-		},
-		metricsRecorder,
-		logger,
-	)
+	mutatingWebhook, err := mutating.NewWebhook(mutating.WebhookConfig{
+		ID:      "init-gtoken-pods",
+		Obj:     &corev1.Pod{},
+		Mutator: mutator,
+		Logger:  whlogrus.NewLogrus(log.NewEntry(logger)),
+	})
+	if err != nil {
+		logger.WithError(err).Fatal("error creating mutating webhook")
+	}
+	podHandler := handlerFor(mutatingWebhook, metricsRecorder, logger)
+
+	validatingWebhook, err := validating.NewWebhook(validating.WebhookConfig{
+		ID:        "validate-gtoken-pods",
+		Obj:       &corev1.Pod{},
+		Validator: validating.ValidatorFunc(webhook.podValidator),
+		Logger:    whlogrus.NewLogrus(log.NewEntry(logger)),
+	})
+	if err != nil {
+		logger.WithError(err).Fatal("error creating validating webhook")
+	}
+	validatePodHandler := handlerFor(validatingWebhook, metricsRecorder, logger)
+
+	saValidatingWebhook, err := validating.NewWebhook(validating.WebhookConfig{
+		ID:        "validate-gtoken-serviceaccounts",
+		Obj:       &corev1.ServiceAccount{},
+		Validator: validating.ValidatorFunc(webhook.serviceAccountValidator),
+		Logger:    whlogrus.NewLogrus(log.NewEntry(logger)),
+	})
+	if err != nil {
+		logger.WithError(err).Fatal("error creating service account validating webhook")
+	}
+	validateServiceAccountHandler := handlerFor(saValidatingWebhook, metricsRecorder, logger)
 
 	mux := http.NewServeMux()
 	mux.Handle("/pods", podHandler)
+	mux.Handle("/validate-pods", validatePodHandler)
+	mux.Handle("/validate-serviceaccounts", validateServiceAccountHandler)
 	mux.Handle("/healthz", http.HandlerFunc(healthzHandler))
+	mux.Handle("/readyz", ready)
 
 	telemetryAddress := c.String("telemetry-listen-address")
 	listenAddress := c.String("listen-address")
@@ -359,17 +643,72 @@ func runWebhook(c *cli.Context) error {
 		mux.Handle("/metrics", promhttp.Handler())
 	}
 
-	if tlsCertFile == "" && tlsPrivateKeyFile == "" {
+	server := &http.Server{
+		Addr:              listenAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	var certs *certProvider
+	switch {
+	case c.Bool("self-managed-certs"):
+		certs = &certProvider{}
+		identity, err := os.Hostname()
+		if err != nil {
+			logger.WithError(err).Fatal("error getting hostname for leader election")
+		}
+		manager := &certManager{
+			k8sClient:               k8sClient,
+			namespace:               c.String("namespace"),
+			secretName:              c.String("certs-secret"),
+			serviceName:             c.String("service-name"),
+			mutatingWebhookConfig:   c.String("mutating-webhook-config"),
+			validatingWebhookConfig: c.String("validating-webhook-config"),
+			certValidity:            c.Duration("cert-validity"),
+			rotateBefore:            c.Duration("cert-rotate-before"),
+		}
+		if manager.rotateBefore >= manager.certValidity {
+			logger.Fatal("cert-rotate-before must be shorter than cert-validity")
+		}
+		// generate, rotate and load self-managed certificates
+		go manager.run(ctx, identity, certs, c.Duration("cert-check-interval"))
+	case tlsCertFile != "" || tlsPrivateKeyFile != "":
+		certs, err = newCertProvider(tlsCertFile, tlsPrivateKeyFile)
+		if err != nil {
+			logger.WithError(err).Fatal("error loading TLS certificate")
+		}
+		// reload rotated certificate without restart
+		go certs.watch(c.Duration("tls-reload-interval"), ctx.Done())
+	}
+	if certs != nil {
+		ready.addCheck("TLS certificate", certs.loaded)
+	}
+
+	// graceful shutdown on SIGTERM
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		ready.shutdown(server, c.Duration("shutdown-drain-delay"), c.Duration("shutdown-timeout"))
+	}()
+
+	if certs == nil {
 		logger.Infof("listening on http://%s", listenAddress)
-		err = http.ListenAndServe(listenAddress, mux)
+		err = server.ListenAndServe()
 	} else {
+		server.TLSConfig, err = newTLSConfig(certs, c.String("tls-min-version"), c.StringSlice("tls-cipher-suites"))
+		if err != nil {
+			logger.WithError(err).Fatal("error creating TLS config")
+		}
 		logger.Infof("listening on https://%s", listenAddress)
-		err = http.ListenAndServeTLS(listenAddress, tlsCertFile, tlsPrivateKeyFile, mux)
+		err = server.ListenAndServeTLS("", "")
 	}
 
-	if err != nil {
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		logger.WithError(err).Fatal("error serving webhook")
 	}
+	// wait for in-flight requests
+	<-shutdown
+	logger.Info("webhook server stopped")
 
 	return nil
 }
@@ -413,6 +752,16 @@ func main() {
 					Usage: "webhook server listen address",
 					Value: ":8443",
 				},
+				cli.DurationFlag{
+					Name:  "shutdown-drain-delay",
+					Usage: "delay between SIGTERM and server shutdown, while webhook reports not ready and keeps serving requests",
+					Value: defaultShutdownDrainDelay,
+				},
+				cli.DurationFlag{
+					Name:  "shutdown-timeout",
+					Usage: "timeout to wait for in-flight requests on server shutdown",
+					Value: defaultShutdownTimeout,
+				},
 				cli.StringFlag{
 					Name:  "telemetry-listen-address",
 					Usage: "specify a dedicated prometheus metrics listen address (using listen-address, if empty)",
@@ -425,6 +774,65 @@ func main() {
 					Name:  "tls-private-key-file",
 					Usage: "TLS private key file",
 				},
+				cli.DurationFlag{
+					Name:  "tls-reload-interval",
+					Usage: "interval to check TLS certificate and private key files for changes",
+					Value: defaultTLSReloadInterval,
+				},
+				cli.StringFlag{
+					Name:  "tls-min-version",
+					Usage: "minimal TLS version: 1.0, 1.1, 1.2 or 1.3",
+					Value: "1.2",
+				},
+				cli.StringSliceFlag{
+					Name:  "tls-cipher-suites",
+					Usage: "allowed TLS cipher suites for TLS 1.2 and lower, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 (Go defaults, if empty)",
+				},
+				cli.BoolFlag{
+					Name:  "self-managed-certs",
+					Usage: "generate and rotate CA and TLS certificate, stored in Secret, and patch webhook configurations caBundle (ignores tls-cert-file and tls-private-key-file)",
+				},
+				cli.StringFlag{
+					Name:   "namespace",
+					Usage:  "gtoken-webhook namespace for self-managed certificates Secret and leader election Lease",
+					Value:  "gtoken",
+					EnvVar: "POD_NAMESPACE",
+				},
+				cli.StringFlag{
+					Name:  "certs-secret",
+					Usage: "self-managed certificates Secret (and leader election Lease) name",
+					Value: "gtoken-webhook-certs",
+				},
+				cli.StringFlag{
+					Name:  "service-name",
+					Usage: "gtoken-webhook Service name for self-managed TLS certificate",
+					Value: "gtoken-webhook-svc",
+				},
+				cli.StringFlag{
+					Name:  "mutating-webhook-config",
+					Usage: "mutating webhook configuration to patch with self-managed CA",
+					Value: "mutating-gtoken-webhook-cfg",
+				},
+				cli.StringFlag{
+					Name:  "validating-webhook-config",
+					Usage: "validating webhook configuration to check (matchConditions) and patch with self-managed CA, if exists",
+					Value: "validating-gtoken-webhook-cfg",
+				},
+				cli.DurationFlag{
+					Name:  "cert-validity",
+					Usage: "self-managed TLS certificate validity",
+					Value: defaultCertValidity,
+				},
+				cli.DurationFlag{
+					Name:  "cert-rotate-before",
+					Usage: "rotate self-managed certificates this time before expiration",
+					Value: defaultCertRotateBefore,
+				},
+				cli.DurationFlag{
+					Name:  "cert-check-interval",
+					Usage: "interval to check self-managed certificates and webhook configurations caBundle",
+					Value: defaultCertCheckInterval,
+				},
 				cli.StringFlag{
 					Name:  "image",
 					Usage: "Docker image with secrets-init utility on board",
@@ -435,6 +843,31 @@ func main() {
 					Usage: "Docker image pull policy",
 					Value: string(corev1.PullIfNotPresent),
 				},
+				cli.StringFlag{
+					Name:  "resource-profile",
+					Usage: "gtoken containers resource profile: default or autopilot (GKE Autopilot minimums)",
+					Value: defaultProfile,
+				},
+				cli.StringFlag{
+					Name:  "cpu-request",
+					Usage: "gtoken containers CPU request (resource profile value, if empty)",
+				},
+				cli.StringFlag{
+					Name:  "memory-request",
+					Usage: "gtoken containers memory request (resource profile value, if empty)",
+				},
+				cli.StringFlag{
+					Name:  "cpu-limit",
+					Usage: "gtoken containers CPU limit (resource profile value, if empty)",
+				},
+				cli.StringFlag{
+					Name:  "memory-limit",
+					Usage: "gtoken containers memory limit (resource profile value, if empty)",
+				},
+				cli.BoolTFlag{
+					Name:  "restricted-security-context",
+					Usage: "run gtoken containers with security context compliant with Pod Security restricted profile",
+				},
 				cli.StringFlag{
 					Name:  "volume-name",
 					Usage: "mount volume name",
@@ -450,6 +883,68 @@ func main() {
 					Usage: "token file name",
 					Value: tokenFileName,
 				},
+				cli.DurationFlag{
+					Name:  "wait-for-metadata",
+					Usage: "wait for GKE metadata server in gtoken init container, e.g. 1m (disabled, if not specified; requires gtoken image with --wait-for-metadata flag)",
+				},
+				cli.BoolFlag{
+					Name:  "pod-role-arn-override",
+					Usage: "allow Pod annotation to override Service Account AWS Role ARN (restrict roles with --policy-file)",
+				},
+				cli.BoolTFlag{
+					Name:  "informer-cache",
+					Usage: "use informer cache for Service Account and Namespace lookups",
+				},
+				cli.BoolFlag{
+					Name:  "gtoken-bindings",
+					Usage: "watch GTokenBinding resources (GTokenBinding CRD must be installed)",
+				},
+				cli.StringFlag{
+					Name:  "role-arn-template",
+					Usage: "AWS role ARN template for Service Accounts without AWS role in opted in Namespaces (fields: ClusterName, AccountID, Namespace, ServiceAccount, Labels, NamespaceLabels)",
+				},
+				cli.StringFlag{
+					Name:  "cluster-name",
+					Usage: "cluster name for AWS role ARN template",
+				},
+				cli.StringSliceFlag{
+					Name:  "namespace-account",
+					Usage: "namespace=account AWS account ID mapping for AWS role ARN template; use * namespace for default account",
+				},
+				cli.StringFlag{
+					Name:  "policy-file",
+					Usage: "AWS role authorization policy file (YAML); all AWS roles are allowed, if empty",
+				},
+				cli.StringFlag{
+					Name:  "aws-region",
+					Usage: "default AWS region (AWS_REGION and AWS_DEFAULT_REGION) to inject",
+				},
+				cli.BoolFlag{
+					Name:  "sts-regional-endpoints",
+					Usage: "inject AWS_STS_REGIONAL_ENDPOINTS=regional to use regional AWS STS endpoint",
+				},
+				cli.StringFlag{
+					Name:  "sts-endpoint",
+					Usage: "default AWS STS endpoint URL (AWS_ENDPOINT_URL_STS) to inject",
+				},
+				cli.StringFlag{
+					Name:  "injection-mode",
+					Usage: "gtoken containers injection mode: native-sidecar, sidecar or init-only",
+					Value: string(sidecarMode),
+				},
+				cli.BoolFlag{
+					Name:  "override-aws-env",
+					Usage: "override AWS environment variables already set in Pod containers",
+				},
+				cli.StringFlag{
+					Name:  "session-name-template",
+					Usage: "AWS role session name template (fields: Namespace, PodName, GenerateName, ServiceAccount, RequestUID, Random, Labels)",
+					Value: defaultSessionNameTemplate,
+				},
+				cli.BoolFlag{
+					Name:  "session-name-random-suffix",
+					Usage: "append crypto-random suffix to AWS role session name",
+				},
 			},
 			Usage:       "mutation admission webhook",
 			Description: "run mutation admission webhook server",
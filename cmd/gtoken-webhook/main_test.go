@@ -3,15 +3,19 @@ package main
 import (
 	"context"
 	"os"
-	"strings"
 	"testing"
+	"time"
 
 	cmp "github.com/google/go-cmp/cmp"
+	whmodel "github.com/slok/kubewebhook/v2/pkg/model"
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	fake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestMain(m *testing.M) {
@@ -67,7 +71,7 @@ func Test_mutatingWebhook_mutateContainers(t *testing.T) {
 					Env: []corev1.EnvVar{
 						{Name: awsWebIdentityTokenFile, Value: "/test-volume-path/test-token"},
 						{Name: awsRoleArn, Value: "arn:aws:iam::123456789012:role/testrole"},
-						{Name: awsRoleSessionName, Value: "gtoken-webhook-" + strings.Repeat("0", 16)},
+						{Name: awsRoleSessionName, Value: "test-session"},
 					},
 				},
 			},
@@ -103,7 +107,7 @@ func Test_mutatingWebhook_mutateContainers(t *testing.T) {
 					Env: []corev1.EnvVar{
 						{Name: awsWebIdentityTokenFile, Value: "/test-volume-path/test-token"},
 						{Name: awsRoleArn, Value: "arn:aws:iam::123456789012:role/testrole"},
-						{Name: awsRoleSessionName, Value: "gtoken-webhook-" + strings.Repeat("0", 16)},
+						{Name: awsRoleSessionName, Value: "test-session"},
 					},
 				},
 				{
@@ -113,7 +117,7 @@ func Test_mutatingWebhook_mutateContainers(t *testing.T) {
 					Env: []corev1.EnvVar{
 						{Name: awsWebIdentityTokenFile, Value: "/test-volume-path/test-token"},
 						{Name: awsRoleArn, Value: "arn:aws:iam::123456789012:role/testrole"},
-						{Name: awsRoleSessionName, Value: "gtoken-webhook-" + strings.Repeat("0", 16)},
+						{Name: awsRoleSessionName, Value: "test-session"},
 					},
 				},
 			},
@@ -143,7 +147,7 @@ func Test_mutatingWebhook_mutateContainers(t *testing.T) {
 				volumePath: tt.fields.volumePath,
 				tokenFile:  tt.fields.tokenFile,
 			}
-			got := mw.mutateContainers(tt.args.containers, tt.args.roleArn)
+			got := mw.mutateContainers(tt.args.containers, &podInjection{roleArn: tt.args.roleArn, sessionName: "test-session"})
 			if got != tt.mutated {
 				t.Errorf("mutatingWebhook.mutateContainers() = %v, want %v", got, tt.mutated)
 			}
@@ -157,15 +161,17 @@ func Test_mutatingWebhook_mutateContainers(t *testing.T) {
 //nolint:funlen
 func Test_mutatingWebhook_mutatePod(t *testing.T) {
 	type fields struct {
-		image      string
-		pullPolicy string
-		volumeName string
-		volumePath string
-		tokenFile  string
+		image           string
+		pullPolicy      string
+		volumeName      string
+		volumePath      string
+		tokenFile       string
+		waitForMetadata time.Duration
 	}
 	type args struct {
 		pod                *corev1.Pod
 		ns                 string
+		uid                string
 		serviceAccountName string
 		annotations        map[string]string
 		dryRun             bool
@@ -180,14 +186,16 @@ func Test_mutatingWebhook_mutatePod(t *testing.T) {
 		{
 			name: "mutate pod",
 			fields: fields{
-				image:      "doitintl/gtoken:test",
-				pullPolicy: "Always",
-				volumeName: "test-volume-name",
-				volumePath: "/test-volume-path",
-				tokenFile:  "test-token",
+				image:           "doitintl/gtoken:test",
+				pullPolicy:      "Always",
+				volumeName:      "test-volume-name",
+				volumePath:      "/test-volume-path",
+				tokenFile:       "test-token",
+				waitForMetadata: time.Minute,
 			},
 			args: args{
 				pod: &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{GenerateName: "test-pod-"},
 					Spec: corev1.PodSpec{
 						Containers: []corev1.Container{
 							{
@@ -199,26 +207,32 @@ func Test_mutatingWebhook_mutatePod(t *testing.T) {
 					},
 				},
 				ns:                 "test-namespace",
+				uid:                "a1b2c3d4-e5f6-7890-abcd-ef1234567890",
 				serviceAccountName: "test-sa",
 				annotations:        map[string]string{awsRoleArnKey: "arn:aws:iam::123456789012:role/testrole"},
 			},
 			wantedPod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "test-pod-",
+					Annotations:  map[string]string{statusAnnotationKey: injectedStatus},
+				},
 				Spec: corev1.PodSpec{
 					InitContainers: []corev1.Container{
 						{
 							Name:    "generate-gcp-id-token",
 							Image:   "doitintl/gtoken:test",
-							Command: []string{"/gtoken", "--file=/test-volume-path/test-token", "--refresh=false"},
+							Command: []string{"/gtoken", "--file=/test-volume-path/test-token", "--refresh=false", "--wait-for-metadata=1m0s"},
 							Resources: corev1.ResourceRequirements{
 								Requests: corev1.ResourceList{
-									corev1.ResourceCPU:    resource.MustParse(requestsCPU),
-									corev1.ResourceMemory: resource.MustParse(requestsMemory),
+									corev1.ResourceCPU:    resource.MustParse("5m"),
+									corev1.ResourceMemory: resource.MustParse("10Mi"),
 								},
 								Limits: corev1.ResourceList{
-									corev1.ResourceCPU:    resource.MustParse(limitsCPU),
-									corev1.ResourceMemory: resource.MustParse(limitsMemory),
+									corev1.ResourceCPU:    resource.MustParse("20m"),
+									corev1.ResourceMemory: resource.MustParse("50Mi"),
 								},
 							},
+							SecurityContext: restrictedSecurityContext(),
 							VolumeMounts: []corev1.VolumeMount{
 								{
 									Name:      "test-volume-name",
@@ -236,7 +250,7 @@ func Test_mutatingWebhook_mutatePod(t *testing.T) {
 							Env: []corev1.EnvVar{
 								{Name: awsWebIdentityTokenFile, Value: "/test-volume-path/test-token"},
 								{Name: awsRoleArn, Value: "arn:aws:iam::123456789012:role/testrole"},
-								{Name: awsRoleSessionName, Value: "gtoken-webhook-" + strings.Repeat("0", 16)},
+								{Name: awsRoleSessionName, Value: "test-namespace-test-pod-a1b2c3d4"},
 							},
 						},
 						{
@@ -245,14 +259,15 @@ func Test_mutatingWebhook_mutatePod(t *testing.T) {
 							Command: []string{"/gtoken", "--file=/test-volume-path/test-token", "--refresh=true"},
 							Resources: corev1.ResourceRequirements{
 								Requests: corev1.ResourceList{
-									corev1.ResourceCPU:    resource.MustParse(requestsCPU),
-									corev1.ResourceMemory: resource.MustParse(requestsMemory),
+									corev1.ResourceCPU:    resource.MustParse("5m"),
+									corev1.ResourceMemory: resource.MustParse("10Mi"),
 								},
 								Limits: corev1.ResourceList{
-									corev1.ResourceCPU:    resource.MustParse(limitsCPU),
-									corev1.ResourceMemory: resource.MustParse(limitsMemory),
+									corev1.ResourceCPU:    resource.MustParse("20m"),
+									corev1.ResourceMemory: resource.MustParse("50Mi"),
 								},
 							},
+							SecurityContext: restrictedSecurityContext(),
 							VolumeMounts: []corev1.VolumeMount{
 								{
 									Name:      "test-volume-name",
@@ -287,14 +302,17 @@ func Test_mutatingWebhook_mutatePod(t *testing.T) {
 				},
 			}
 			mw := &mutatingWebhook{
-				k8sClient:  fake.NewSimpleClientset(sa),
-				image:      tt.fields.image,
-				pullPolicy: tt.fields.pullPolicy,
-				volumeName: tt.fields.volumeName,
-				volumePath: tt.fields.volumePath,
-				tokenFile:  tt.fields.tokenFile,
+				k8sClient:       fake.NewSimpleClientset(sa),
+				image:           tt.fields.image,
+				pullPolicy:      tt.fields.pullPolicy,
+				volumeName:      tt.fields.volumeName,
+				volumePath:      tt.fields.volumePath,
+				tokenFile:       tt.fields.tokenFile,
+				waitForMetadata: tt.fields.waitForMetadata,
+				resources:       newTestResources(t),
+				securityContext: restrictedSecurityContext(),
 			}
-			if err := mw.mutatePod(context.TODO(), tt.args.pod, tt.args.ns, tt.args.dryRun); (err != nil) != tt.wantErr {
+			if _, err := mw.mutatePod(context.TODO(), tt.args.pod, &whmodel.AdmissionReview{ID: tt.args.uid, Namespace: tt.args.ns, DryRun: tt.args.dryRun}); (err != nil) != tt.wantErr {
 				t.Errorf("mutatingWebhook.mutatePod() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			if !cmp.Equal(tt.args.pod, tt.wantedPod) {
@@ -303,3 +321,185 @@ func Test_mutatingWebhook_mutatePod(t *testing.T) {
 		})
 	}
 }
+
+// getRoleArnEnv returns AWS_ROLE_ARN env var of the first Pod container
+func getRoleArnEnv(pod *corev1.Pod) string {
+	for _, env := range pod.Spec.Containers[0].Env {
+		if env.Name == awsRoleArn {
+			return env.Value
+		}
+	}
+	return ""
+}
+
+//nolint:funlen
+func Test_mutatingWebhook_mutatePod_podAnnotations(t *testing.T) {
+	const (
+		saRoleArn  = "arn:aws:iam::123456789012:role/sa-role"
+		podRoleArn = "arn:aws:iam::123456789012:role/pod-role"
+	)
+	tests := []struct {
+		name               string
+		podAnnotations     map[string]string
+		saAnnotations      map[string]string
+		podRoleArnOverride bool
+		wantRoleArn        string
+	}{
+		{
+			name:          "inject with Service Account role",
+			saAnnotations: map[string]string{awsRoleArnKey: saRoleArn},
+			wantRoleArn:   saRoleArn,
+		},
+		{
+			name:           "skip pod with inject annotation",
+			podAnnotations: map[string]string{injectAnnotationKey: "false"},
+			saAnnotations:  map[string]string{awsRoleArnKey: saRoleArn},
+		},
+		{
+			name:           "ignore invalid inject annotation",
+			podAnnotations: map[string]string{injectAnnotationKey: "no-way"},
+			saAnnotations:  map[string]string{awsRoleArnKey: saRoleArn},
+			wantRoleArn:    saRoleArn,
+		},
+		{
+			name:               "override Service Account role",
+			podAnnotations:     map[string]string{roleArnAnnotationKey: podRoleArn},
+			saAnnotations:      map[string]string{awsRoleArnKey: saRoleArn},
+			podRoleArnOverride: true,
+			wantRoleArn:        podRoleArn,
+		},
+		{
+			name:               "opt in pod with Service Account without role",
+			podAnnotations:     map[string]string{roleArnAnnotationKey: podRoleArn},
+			podRoleArnOverride: true,
+			wantRoleArn:        podRoleArn,
+		},
+		{
+			name:           "ignore pod role when override is disabled",
+			podAnnotations: map[string]string{roleArnAnnotationKey: podRoleArn},
+			saAnnotations:  map[string]string{awsRoleArnKey: saRoleArn},
+			wantRoleArn:    saRoleArn,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa := &corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-sa", Namespace: "test-namespace", Annotations: tt.saAnnotations},
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Annotations: tt.podAnnotations},
+				Spec: corev1.PodSpec{
+					Containers:         []corev1.Container{{Name: "TestContainer", Image: "test-image"}},
+					ServiceAccountName: "test-sa",
+				},
+			}
+			mw := &mutatingWebhook{
+				k8sClient:          fake.NewSimpleClientset(sa),
+				volumeName:         "test-volume-name",
+				volumePath:         "/test-volume-path",
+				tokenFile:          "test-token",
+				podRoleArnOverride: tt.podRoleArnOverride,
+			}
+			if _, err := mw.mutatePod(context.TODO(), pod, &whmodel.AdmissionReview{Namespace: "test-namespace"}); err != nil {
+				t.Fatalf("mutatingWebhook.mutatePod() error = %v", err)
+			}
+			if got := getRoleArnEnv(pod); got != tt.wantRoleArn {
+				t.Errorf("mutatingWebhook.mutatePod() role ARN = %v, want %v", got, tt.wantRoleArn)
+			}
+			if tt.wantRoleArn == "" && len(pod.Spec.Containers) != 1 {
+				t.Errorf("mutatingWebhook.mutatePod() mutated skipped pod")
+			}
+		})
+	}
+}
+
+//nolint:funlen
+func Test_mutatingWebhook_podMutator_serviceAccountLookup(t *testing.T) {
+	const saRoleArn = "arn:aws:iam::123456789012:role/sa-role"
+	tests := []struct {
+		name               string
+		serviceAccountName string
+		serviceAccounts    []runtime.Object
+		getError           error
+		podAnnotations     map[string]string
+		wantRoleArn        string
+		wantWarnings       int
+		wantErr            bool
+	}{
+		{
+			name:               "service account",
+			serviceAccountName: "test-sa",
+			serviceAccounts:    []runtime.Object{newServiceAccount("test-sa", saRoleArn)},
+			wantRoleArn:        saRoleArn,
+		},
+		{
+			name:            "empty service account name resolves to default",
+			serviceAccounts: []runtime.Object{newServiceAccount("default", saRoleArn)},
+			wantRoleArn:     saRoleArn,
+		},
+		{
+			name:               "service account not found",
+			serviceAccountName: "missing-sa",
+			wantWarnings:       1,
+		},
+		{
+			name:               "service account not found with pod role",
+			serviceAccountName: "missing-sa",
+			podAnnotations:     map[string]string{roleArnAnnotationKey: saRoleArn},
+			wantRoleArn:        saRoleArn,
+			wantWarnings:       1,
+		},
+		{
+			name:               "transient error",
+			serviceAccountName: "test-sa",
+			serviceAccounts:    []runtime.Object{newServiceAccount("test-sa", saRoleArn)},
+			getError:           k8serrors.NewServiceUnavailable("etcd is unavailable"),
+			wantErr:            true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(tt.serviceAccounts...)
+			if tt.getError != nil {
+				client.PrependReactor("get", "serviceaccounts", func(k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, tt.getError
+				})
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Annotations: tt.podAnnotations},
+				Spec: corev1.PodSpec{
+					Containers:         []corev1.Container{{Name: "app"}},
+					ServiceAccountName: tt.serviceAccountName,
+				},
+			}
+			mw := &mutatingWebhook{
+				k8sClient:          client,
+				volumeName:         tokenVolumeName,
+				volumePath:         tokenVolumePath,
+				tokenFile:          tokenFileName,
+				podRoleArnOverride: true,
+			}
+			result, err := mw.podMutator(context.TODO(), &whmodel.AdmissionReview{Namespace: "test-namespace"}, pod)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("mutatingWebhook.podMutator() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := len(result.Warnings); got != tt.wantWarnings {
+				t.Errorf("mutatingWebhook.podMutator() warnings = %v, want %d", result.Warnings, tt.wantWarnings)
+			}
+			if got := getRoleArnEnv(pod); got != tt.wantRoleArn {
+				t.Errorf("mutatingWebhook.podMutator() role ARN = %v, want %v", got, tt.wantRoleArn)
+			}
+		})
+	}
+}
+
+func newServiceAccount(name, roleArn string) *corev1.ServiceAccount {
+	return &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+		Name:        name,
+		Namespace:   "test-namespace",
+		Annotations: map[string]string{awsRoleArnKey: roleArn},
+	}}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// Pod mutation outcomes
+	injectedOutcome = "injected"
+	skippedOutcome  = "skipped"
+	deniedOutcome   = "denied"
+	errorOutcome    = "error"
+
+	// Pod mutation skip reasons
+	optOutSkipReason          = "opt-out"
+	alreadyInjectedSkipReason = "already-injected"
+	noRoleSkipReason          = "no-role"
+	notAllowedSkipReason      = "role-not-allowed"
+	noContainersSkipReason    = "no-containers"
+
+	// target cloud of injected credentials
+	awsCloud = "aws"
+
+	// Service Account lookup sources
+	cacheSource = "cache"
+	apiSource   = "api"
+)
+
+var (
+	mutations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gtoken_webhook",
+		Name:      "mutations_total",
+		Help:      "Number of Pod mutations by namespace, outcome (injected, skipped, denied or error), skip reason, injection mode and target cloud.",
+	}, []string{"namespace", "outcome", "reason", "mode", "cloud"})
+
+	serviceAccountLookupDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "gtoken_webhook",
+		Name:      "service_account_lookup_duration_seconds",
+		Help:      "Service Account lookup latency by source (cache or api).",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"source"})
+)
+
+// mutationResult describes Pod mutation outcome
+type mutationResult struct {
+	outcome string
+	// skip reason
+	reason string
+	// injection mode of injected Pod
+	mode injectionMode
+}
+
+// skipped returns skipped Pod mutation result
+func skipped(reason string) mutationResult {
+	return mutationResult{outcome: skippedOutcome, reason: reason}
+}
+
+// recordMutation records Pod mutation metrics
+func recordMutation(namespace string, result mutationResult) {
+	var cloud string
+	if result.outcome == injectedOutcome {
+		cloud = awsCloud
+	}
+	mutations.WithLabelValues(namespace, result.outcome, result.reason, string(result.mode), cloud).Inc()
+}
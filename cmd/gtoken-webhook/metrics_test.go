@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	whmodel "github.com/slok/kubewebhook/v2/pkg/model"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// lookupCount returns number of Service Account lookups from source recorded in latency histogram
+func lookupCount(t *testing.T, source string) uint64 {
+	metric := &dto.Metric{}
+	if err := serviceAccountLookupDuration.WithLabelValues(source).(prometheus.Histogram).Write(metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
+
+//nolint:funlen
+func Test_mutatingWebhook_mutatePod_metrics(t *testing.T) {
+	tests := []struct {
+		name           string
+		namespace      string
+		saRoleArn      string
+		podAnnotations map[string]string
+		lookupErr      error
+		labels         []string
+	}{
+		{
+			name:      "injected",
+			namespace: "metrics-injected",
+			saRoleArn: "arn:aws:iam::123456789012:role/app",
+			labels:    []string{"metrics-injected", injectedOutcome, "", string(sidecarMode), awsCloud},
+		},
+		{
+			name:      "no role",
+			namespace: "metrics-no-role",
+			labels:    []string{"metrics-no-role", skippedOutcome, noRoleSkipReason, "", ""},
+		},
+		{
+			name:           "opt-out",
+			namespace:      "metrics-opt-out",
+			saRoleArn:      "arn:aws:iam::123456789012:role/app",
+			podAnnotations: map[string]string{injectAnnotationKey: "false"},
+			labels:         []string{"metrics-opt-out", skippedOutcome, optOutSkipReason, "", ""},
+		},
+		{
+			name:           "no containers",
+			namespace:      "metrics-no-containers",
+			saRoleArn:      "arn:aws:iam::123456789012:role/app",
+			podAnnotations: map[string]string{excludeContainersAnnotationKey: "app"},
+			labels:         []string{"metrics-no-containers", skippedOutcome, noContainersSkipReason, "", ""},
+		},
+		{
+			name:      "error",
+			namespace: "metrics-error",
+			lookupErr: errors.New("etcd is unavailable"),
+			labels:    []string{"metrics-error", errorOutcome, "", "", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "test-sa", Namespace: tt.namespace}}
+			if tt.saRoleArn != "" {
+				sa.Annotations = map[string]string{awsRoleArnKey: tt.saRoleArn}
+			}
+			client := fake.NewSimpleClientset(sa)
+			if tt.lookupErr != nil {
+				client.PrependReactor("get", "serviceaccounts", func(k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, tt.lookupErr
+				})
+			}
+			mw := &mutatingWebhook{
+				k8sClient:  client,
+				volumeName: tokenVolumeName,
+				volumePath: tokenVolumePath,
+				tokenFile:  tokenFileName,
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Annotations: tt.podAnnotations},
+				Spec: corev1.PodSpec{
+					Containers:         []corev1.Container{{Name: "app"}},
+					ServiceAccountName: "test-sa",
+				},
+			}
+			lookups := lookupCount(t, apiSource)
+			_, _ = mw.mutatePod(context.TODO(), pod, &whmodel.AdmissionReview{Namespace: tt.namespace})
+			if got := testutil.ToFloat64(mutations.WithLabelValues(tt.labels...)); got != 1 {
+				t.Errorf("mutatingWebhook.mutatePod() mutations%v = %v, want 1", tt.labels, got)
+			}
+			// opted out Pods are skipped before Service Account lookup
+			if wantLookups := lookups + 1; tt.podAnnotations[injectAnnotationKey] != "false" && lookupCount(t, apiSource) != wantLookups {
+				t.Errorf("mutatingWebhook.mutatePod() service account lookups = %d, want %d", lookupCount(t, apiSource), wantLookups)
+			}
+		})
+	}
+}
+
+func Test_mutatingWebhook_getServiceAccount_source(t *testing.T) {
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "test-sa", Namespace: "test-namespace"}}
+	mw := &mutatingWebhook{cache: newK8sCache(fake.NewSimpleClientset(sa))}
+	// informers are not started: cache miss is served by live GET
+	cached, api := lookupCount(t, cacheSource), lookupCount(t, apiSource)
+	if _, _, err := mw.getServiceAccount(context.TODO(), "test-sa", "test-namespace"); err != nil {
+		t.Fatal(err)
+	}
+	if got := lookupCount(t, apiSource); got != api+1 {
+		t.Errorf("mutatingWebhook.getServiceAccount() api lookups = %d, want %d", got, api+1)
+	}
+	if got := lookupCount(t, cacheSource); got != cached {
+		t.Errorf("mutatingWebhook.getServiceAccount() cache lookups = %d, want %d", got, cached)
+	}
+	// cache hit
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if !mw.cache.start(stopCh) {
+		t.Fatal("k8sCache.start() failed to sync")
+	}
+	if _, _, err := mw.getServiceAccount(context.TODO(), "test-sa", "test-namespace"); err != nil {
+		t.Fatal(err)
+	}
+	if got := lookupCount(t, cacheSource); got != cached+1 {
+		t.Errorf("mutatingWebhook.getServiceAccount() cache lookups = %d, want %d", got, cached+1)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/kubernetes"
+)
+
+// injectionMode defines how gtoken containers are injected into the Pod
+type injectionMode string
+
+const (
+	// gtoken init container and sidekick refresh container, started as native sidecar (init container with restartPolicy: Always)
+	nativeSidecarMode injectionMode = "native-sidecar"
+	// gtoken init container and sidekick refresh container, appended to Pod containers
+	sidecarMode injectionMode = "sidecar"
+	// gtoken init container only; token is not refreshed (for short Jobs)
+	initOnlyMode injectionMode = "init-only"
+
+	// Pod, Service Account or Namespace annotation key; used to override injection mode
+	injectionModeAnnotationKey = "gtoken.doit-intl.com/injection-mode"
+)
+
+// Kubernetes version with native sidecar containers enabled by default
+var nativeSidecarVersion = version.MustParseGeneric("1.29.0")
+
+func parseInjectionMode(value string) (injectionMode, error) {
+	switch mode := injectionMode(value); mode {
+	case nativeSidecarMode, sidecarMode, initOnlyMode:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unsupported injection mode %q: use one of %s, %s, %s", value, nativeSidecarMode, sidecarMode, initOnlyMode)
+	}
+}
+
+// check if Kubernetes cluster supports native sidecar containers
+func nativeSidecarsSupported(k8sClient kubernetes.Interface) (bool, error) {
+	info, err := k8sClient.Discovery().ServerVersion()
+	if err != nil {
+		return false, err
+	}
+	v, err := version.ParseGeneric(info.GitVersion)
+	if err != nil {
+		return false, err
+	}
+	return v.AtLeast(nativeSidecarVersion), nil
+}
+
+// get Pod injection mode: Pod > GTokenBinding > Service Account > Namespace annotation > webhook configuration
+func (mw *mutatingWebhook) podInjectionMode(pod *corev1.Pod, sa *corev1.ServiceAccount, binding *gtokenBinding, namespace *corev1.Namespace) injectionMode {
+	values := annotationValues(injectionModeAnnotationKey, pod)
+	if binding != nil && binding.Spec.Mode != "" {
+		values = append(values, binding.Spec.Mode)
+	}
+	values = append(values, annotationValues(injectionModeAnnotationKey, sa, namespace)...)
+	mode := mw.injectionMode
+	if mode == "" {
+		mode = sidecarMode
+	}
+	for _, value := range values {
+		valueMode, err := parseInjectionMode(value)
+		if err != nil {
+			logger.WithField("pod", pod.Name).WithError(err).Warnf("ignoring invalid %s value", injectionModeAnnotationKey)
+			continue
+		}
+		mode = valueMode
+		break
+	}
+	if mode == nativeSidecarMode && !mw.nativeSidecars {
+		logger.WithField("pod", pod.Name).Warn("native sidecar containers are not supported by cluster, falling back to sidecar mode")
+		mode = sidecarMode
+	}
+	return mode
+}
+
+// inject gtoken containers into Pod according to injection mode
+func (mw *mutatingWebhook) injectGtokenContainers(pod *corev1.Pod, injection *podInjection) {
+	initContainers := []corev1.Container{mw.getGtokenContainer(initContainerName, injection, false)}
+	sidekick := mw.getGtokenContainer(sidekickContainerName, injection, true)
+	switch injection.mode {
+	case nativeSidecarMode:
+		// start sidekick right after gtoken init container, before other init containers
+		restartPolicy := corev1.ContainerRestartPolicyAlways
+		sidekick.RestartPolicy = &restartPolicy
+		initContainers = append(initContainers, sidekick)
+	case sidecarMode:
+		// append sidekick gtoken update container (as last container)
+		pod.Spec.Containers = append(pod.Spec.Containers, sidekick)
+		logger.Debug("successfully appended pod sidekick containers to spec")
+	case initOnlyMode:
+		logger.Debug("skipping sidekick container in init-only mode")
+	}
+	// prepend gtoken init containers (as first init containers)
+	pod.Spec.InitContainers = append(initContainers, pod.Spec.InitContainers...)
+	logger.Debug("successfully prepended pod init containers to spec")
+}
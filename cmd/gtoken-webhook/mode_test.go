@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	cmp "github.com/google/go-cmp/cmp"
+	whmodel "github.com/slok/kubewebhook/v2/pkg/model"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+// containerSpec is a short description of container: name and restart policy
+type containerSpec struct {
+	Name          string
+	RestartPolicy corev1.ContainerRestartPolicy
+}
+
+func containerSpecs(containers []corev1.Container) []containerSpec {
+	specs := make([]containerSpec, 0, len(containers))
+	for _, container := range containers {
+		spec := containerSpec{Name: container.Name}
+		if container.RestartPolicy != nil {
+			spec.RestartPolicy = *container.RestartPolicy
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+//nolint:funlen
+func Test_mutatingWebhook_mutatePod_injectionMode(t *testing.T) {
+	tests := []struct {
+		name               string
+		mode               injectionMode
+		nativeSidecars     bool
+		annotations        map[string]string
+		wantInitContainers []containerSpec
+		wantContainers     []containerSpec
+	}{
+		{
+			name:               "default sidecar",
+			wantInitContainers: []containerSpec{{Name: initContainerName}, {Name: "init"}},
+			wantContainers:     []containerSpec{{Name: "app"}, {Name: sidekickContainerName}},
+		},
+		{
+			name:               "native sidecar",
+			mode:               nativeSidecarMode,
+			nativeSidecars:     true,
+			wantInitContainers: []containerSpec{{Name: initContainerName}, {Name: sidekickContainerName, RestartPolicy: corev1.ContainerRestartPolicyAlways}, {Name: "init"}},
+			wantContainers:     []containerSpec{{Name: "app"}},
+		},
+		{
+			name:               "native sidecar not supported",
+			mode:               nativeSidecarMode,
+			wantInitContainers: []containerSpec{{Name: initContainerName}, {Name: "init"}},
+			wantContainers:     []containerSpec{{Name: "app"}, {Name: sidekickContainerName}},
+		},
+		{
+			name:               "init only",
+			mode:               initOnlyMode,
+			wantInitContainers: []containerSpec{{Name: initContainerName}, {Name: "init"}},
+			wantContainers:     []containerSpec{{Name: "app"}},
+		},
+		{
+			name:               "pod annotation",
+			mode:               sidecarMode,
+			annotations:        map[string]string{injectionModeAnnotationKey: string(initOnlyMode)},
+			wantInitContainers: []containerSpec{{Name: initContainerName}, {Name: "init"}},
+			wantContainers:     []containerSpec{{Name: "app"}},
+		},
+		{
+			name:               "invalid pod annotation",
+			mode:               nativeSidecarMode,
+			nativeSidecars:     true,
+			annotations:        map[string]string{injectionModeAnnotationKey: "daemon"},
+			wantInitContainers: []containerSpec{{Name: initContainerName}, {Name: sidekickContainerName, RestartPolicy: corev1.ContainerRestartPolicyAlways}, {Name: "init"}},
+			wantContainers:     []containerSpec{{Name: "app"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-sa",
+				Namespace:   "test-namespace",
+				Annotations: map[string]string{awsRoleArnKey: "arn:aws:iam::123456789012:role/sa-role"},
+			}}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Annotations: tt.annotations},
+				Spec: corev1.PodSpec{
+					InitContainers:     []corev1.Container{{Name: "init"}},
+					Containers:         []corev1.Container{{Name: "app"}},
+					ServiceAccountName: "test-sa",
+				},
+			}
+			mw := &mutatingWebhook{
+				k8sClient:      fake.NewSimpleClientset(sa),
+				volumeName:     tokenVolumeName,
+				volumePath:     tokenVolumePath,
+				tokenFile:      tokenFileName,
+				injectionMode:  tt.mode,
+				nativeSidecars: tt.nativeSidecars,
+			}
+			if _, err := mw.mutatePod(context.TODO(), pod, &whmodel.AdmissionReview{Namespace: "test-namespace"}); err != nil {
+				t.Fatalf("mutatingWebhook.mutatePod() error = %v", err)
+			}
+			if got := containerSpecs(pod.Spec.InitContainers); !cmp.Equal(got, tt.wantInitContainers) {
+				t.Errorf("mutatingWebhook.mutatePod() init containers = diff %v", cmp.Diff(got, tt.wantInitContainers))
+			}
+			if got := containerSpecs(pod.Spec.Containers); !cmp.Equal(got, tt.wantContainers) {
+				t.Errorf("mutatingWebhook.mutatePod() containers = diff %v", cmp.Diff(got, tt.wantContainers))
+			}
+		})
+	}
+}
+
+func Test_nativeSidecarsSupported(t *testing.T) {
+	tests := []struct {
+		gitVersion string
+		want       bool
+	}{
+		{gitVersion: "v1.27.8-gke.1067004", want: false},
+		{gitVersion: "v1.28.3", want: false},
+		{gitVersion: "v1.29.1-gke.1589017", want: true},
+		{gitVersion: "v1.30.0", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.gitVersion, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			client.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: tt.gitVersion}
+			got, err := nativeSidecarsSupported(client)
+			if err != nil {
+				t.Fatalf("nativeSidecarsSupported() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("nativeSidecarsSupported() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseInjectionMode(t *testing.T) {
+	for _, value := range []string{"native-sidecar", "sidecar", "init-only"} {
+		if mode, err := parseInjectionMode(value); err != nil || string(mode) != value {
+			t.Errorf("parseInjectionMode(%q) = %v, %v", value, mode, err)
+		}
+	}
+	if _, err := parseInjectionMode("daemon"); err == nil {
+		t.Error("parseInjectionMode() expected error for unsupported mode")
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// policyAction defines what webhook does with Pods that use AWS role not allowed by policy
+type policyAction string
+
+const (
+	// reject Pod (requires validating webhook)
+	denyAction policyAction = "deny"
+	// admit Pod without injection
+	skipAction policyAction = "skip"
+
+	// status annotation value for Pods denied by policy
+	deniedStatus = "denied"
+	// Pod annotation key; reason Pod was denied by policy
+	deniedReasonAnnotationKey = "gtoken.doit-intl.com/denied-reason"
+)
+
+// policy maps namespaces and label selectors to allowed AWS roles
+type policy struct {
+	// action for AWS roles not allowed by policy: deny (default) or skip
+	Action policyAction `json:"action,omitempty"`
+	Rules  []policyRule `json:"rules"`
+}
+
+// policyRule allows AWS roles for namespaces and Service Accounts selected by rule; empty selectors select all
+type policyRule struct {
+	// namespace name patterns (* wildcard)
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Namespace label selector
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// Service Account label selector
+	ServiceAccountSelector *metav1.LabelSelector `json:"serviceAccountSelector,omitempty"`
+	// allowed AWS role ARN patterns (* wildcard)
+	AllowedRoleArns []string `json:"allowedRoleArns,omitempty"`
+	// allowed AWS account IDs
+	AllowedAccounts []string `json:"allowedAccounts,omitempty"`
+}
+
+// loadPolicy reads policy from YAML or JSON file
+func loadPolicy(fileName string) (*policy, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	p := &policy{}
+	if err = yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %s: %s", fileName, err.Error())
+	}
+	switch p.Action {
+	case "":
+		p.Action = denyAction
+	case denyAction, skipAction:
+	default:
+		return nil, fmt.Errorf("unsupported policy action %q: use %s or %s", p.Action, denyAction, skipAction)
+	}
+	for _, rule := range p.Rules {
+		for _, selector := range []*metav1.LabelSelector{rule.NamespaceSelector, rule.ServiceAccountSelector} {
+			if _, err = metav1.LabelSelectorAsSelector(selector); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return p, nil
+}
+
+// wildcardMatch matches value against pattern with * wildcard (matches any characters, including /)
+func wildcardMatch(pattern, value string) bool {
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+	matched, err := regexp.MatchString(expr, value)
+	return err == nil && matched
+}
+
+// roleArnAccount returns AWS account ID from role ARN
+func roleArnAccount(roleArn string) string {
+	parts := strings.Split(roleArn, ":")
+	if len(parts) < 5 {
+		return ""
+	}
+	return parts[4]
+}
+
+// selects returns true if rule selects Service Account in Namespace
+func (r *policyRule) selects(sa *corev1.ServiceAccount, namespace *corev1.Namespace) bool {
+	if len(r.Namespaces) > 0 {
+		var matched bool
+		for _, pattern := range r.Namespaces {
+			if wildcardMatch(pattern, namespace.Name) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return selectorMatches(r.NamespaceSelector, namespace.GetLabels()) && selectorMatches(r.ServiceAccountSelector, sa.GetLabels())
+}
+
+// allows returns true if rule allows AWS role ARN
+func (r *policyRule) allows(roleArn string) bool {
+	for _, pattern := range r.AllowedRoleArns {
+		if wildcardMatch(pattern, roleArn) {
+			return true
+		}
+	}
+	account := roleArnAccount(roleArn)
+	for _, allowed := range r.AllowedAccounts {
+		if allowed == account {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns error if AWS role ARN is invalid or not allowed for Service Account in Namespace
+func (p *policy) check(roleArn string, sa *corev1.ServiceAccount, namespace *corev1.Namespace) error {
+	if err := validateRoleArn(roleArn); err != nil {
+		return err
+	}
+	for i := range p.Rules {
+		if p.Rules[i].selects(sa, namespace) && p.Rules[i].allows(roleArn) {
+			return nil
+		}
+	}
+	return fmt.Errorf("AWS role %s is not allowed for service account %s/%s by policy", roleArn, namespace.Name, sa.Name)
+}
+
+// check AWS roles to inject into Pod against policy; nil, if allowed or policy is not configured
+func (mw *mutatingWebhook) checkPolicy(injection *podInjection, sa *corev1.ServiceAccount, namespace *corev1.Namespace) error {
+	if mw.policy == nil {
+		return nil
+	}
+	roleArns := make([]string, 0, len(injection.containerRoleArns)+1)
+	if injection.roleArn != "" {
+		roleArns = append(roleArns, injection.roleArn)
+	}
+	for _, roleArn := range injection.containerRoleArns {
+		roleArns = append(roleArns, roleArn)
+	}
+	for _, roleArn := range roleArns {
+		if err := mw.policy.check(roleArn, sa, namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
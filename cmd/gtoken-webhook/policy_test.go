@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	whmodel "github.com/slok/kubewebhook/v2/pkg/model"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+const testPolicy = `
+action: skip
+rules:
+  - namespaces: ["data-*"]
+    allowedRoleArns: ["arn:aws:iam::123456789012:role/data-*"]
+  - namespaceSelector:
+      matchLabels:
+        team: web
+    serviceAccountSelector:
+      matchLabels:
+        app: frontend
+    allowedAccounts: ["210987654321"]
+`
+
+func writePolicy(t *testing.T, data string) string {
+	fileName := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(fileName, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func Test_loadPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		wantAction policyAction
+		wantErr    bool
+	}{
+		{name: "valid", data: testPolicy, wantAction: skipAction},
+		{name: "default action", data: "rules: []", wantAction: denyAction},
+		{name: "invalid action", data: "action: ignore", wantErr: true},
+		{name: "unknown field", data: "rulez: []", wantErr: true},
+		{
+			name:    "invalid selector",
+			data:    "rules: [{namespaceSelector: {matchExpressions: [{key: team, operator: Like}]}}]",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadPolicy(writePolicy(t, tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Action != tt.wantAction {
+				t.Errorf("loadPolicy() action = %v, want %v", got.Action, tt.wantAction)
+			}
+		})
+	}
+}
+
+func Test_policy_check(t *testing.T) {
+	p, err := loadPolicy(writePolicy(t, testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		roleArn   string
+		namespace *corev1.Namespace
+		saLabels  map[string]string
+		wantErr   bool
+	}{
+		{
+			name:      "allowed role pattern",
+			roleArn:   "arn:aws:iam::123456789012:role/data-reader",
+			namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "data-prod"}},
+		},
+		{
+			name:      "role pattern does not match",
+			roleArn:   "arn:aws:iam::123456789012:role/admin",
+			namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "data-prod"}},
+			wantErr:   true,
+		},
+		{
+			name:      "namespace does not match",
+			roleArn:   "arn:aws:iam::123456789012:role/data-reader",
+			namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web"}},
+			wantErr:   true,
+		},
+		{
+			name:      "allowed account",
+			roleArn:   "arn:aws:iam::210987654321:role/any",
+			namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web", Labels: map[string]string{"team": "web"}}},
+			saLabels:  map[string]string{"app": "frontend"},
+		},
+		{
+			name:      "service account selector does not match",
+			roleArn:   "arn:aws:iam::210987654321:role/any",
+			namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web", Labels: map[string]string{"team": "web"}}},
+			saLabels:  map[string]string{"app": "backend"},
+			wantErr:   true,
+		},
+		{
+			name:      "invalid role ARN",
+			roleArn:   "arn:aws:iam::210987654321:user/any",
+			namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web", Labels: map[string]string{"team": "web"}}},
+			saLabels:  map[string]string{"app": "frontend"},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "test-sa", Labels: tt.saLabels}}
+			if err := p.check(tt.roleArn, sa, tt.namespace); (err != nil) != tt.wantErr {
+				t.Errorf("policy.check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+//nolint:funlen
+func Test_mutatingWebhook_mutatePod_policy(t *testing.T) {
+	tests := []struct {
+		name           string
+		action         policyAction
+		saRoleArn      string
+		wantRoleArn    string
+		wantStatus     string
+		wantReason     string
+		wantAudit      string
+		wantWarnings   int
+		wantContainers int
+	}{
+		{
+			name:           "allowed",
+			action:         denyAction,
+			saRoleArn:      "arn:aws:iam::123456789012:role/data-reader",
+			wantRoleArn:    "arn:aws:iam::123456789012:role/data-reader",
+			wantStatus:     injectedStatus,
+			wantReason:     injectedReason,
+			wantAudit:      injectedStatus,
+			wantContainers: 1,
+		},
+		{
+			name:         "skip",
+			action:       skipAction,
+			saRoleArn:    "arn:aws:iam::123456789012:role/admin",
+			wantReason:   roleNotAllowedReason,
+			wantAudit:    skippedStatus,
+			wantWarnings: 1,
+		},
+		{
+			name:         "deny",
+			action:       denyAction,
+			saRoleArn:    "arn:aws:iam::123456789012:role/admin",
+			wantStatus:   deniedStatus,
+			wantReason:   roleNotAllowedReason,
+			wantAudit:    deniedStatus,
+			wantWarnings: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa := newServiceAccount("test-sa", tt.saRoleArn)
+			sa.Namespace = "data-prod"
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pod"},
+				Spec: corev1.PodSpec{
+					Containers:         []corev1.Container{{Name: "app"}},
+					ServiceAccountName: "test-sa",
+				},
+			}
+			recorder := record.NewFakeRecorder(10)
+			mw := &mutatingWebhook{
+				k8sClient:  fake.NewSimpleClientset(sa),
+				volumeName: tokenVolumeName,
+				volumePath: tokenVolumePath,
+				tokenFile:  tokenFileName,
+				recorder:   recorder,
+				policy: &policy{
+					Action: tt.action,
+					Rules:  []policyRule{{Namespaces: []string{"data-*"}, AllowedRoleArns: []string{"*:role/data-*"}}},
+				},
+			}
+			annotations := &auditAnnotations{values: make(map[string]string)}
+			ctx := context.WithValue(context.TODO(), auditAnnotationsKey{}, annotations)
+			warnings, err := mw.mutatePod(ctx, pod, &whmodel.AdmissionReview{Namespace: "data-prod"})
+			if err != nil {
+				t.Fatalf("mutatingWebhook.mutatePod() error = %v", err)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("mutatingWebhook.mutatePod() warnings = %v, want %d", warnings, tt.wantWarnings)
+			}
+			if got := getRoleArnEnv(pod); got != tt.wantRoleArn {
+				t.Errorf("mutatingWebhook.mutatePod() role ARN = %v, want %v", got, tt.wantRoleArn)
+			}
+			if got := pod.Annotations[statusAnnotationKey]; got != tt.wantStatus {
+				t.Errorf("mutatingWebhook.mutatePod() status = %v, want %v", got, tt.wantStatus)
+			}
+			if got := annotations.values[statusAuditKey]; got != tt.wantAudit {
+				t.Errorf("mutatingWebhook.mutatePod() status audit annotation = %v, want %v", got, tt.wantAudit)
+			}
+			if got := len(pod.Spec.InitContainers); got != tt.wantContainers {
+				t.Errorf("mutatingWebhook.mutatePod() init containers = %d, want %d", got, tt.wantContainers)
+			}
+			select {
+			case event := <-recorder.Events:
+				if !strings.Contains(event, tt.wantReason) {
+					t.Errorf("mutatingWebhook.mutatePod() event = %q, want %s", event, tt.wantReason)
+				}
+			default:
+				t.Errorf("mutatingWebhook.mutatePod() expected %s event", tt.wantReason)
+			}
+		})
+	}
+}
+
+func Test_mutatingWebhook_serviceAccountValidator(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  *policy
+		roleArn string
+		want    bool
+	}{
+		{name: "no role", want: true},
+		{name: "valid role without policy", roleArn: "arn:aws:iam::123456789012:role/admin", want: true},
+		{name: "invalid role without policy", roleArn: "arn:aws:iam::123456789012:admin", want: false},
+		{
+			name:    "allowed by policy",
+			policy:  &policy{Rules: []policyRule{{AllowedAccounts: []string{"123456789012"}}}},
+			roleArn: "arn:aws:iam::123456789012:role/admin",
+			want:    true,
+		},
+		{
+			name:    "not allowed by policy",
+			policy:  &policy{Rules: []policyRule{{AllowedAccounts: []string{"210987654321"}}}},
+			roleArn: "arn:aws:iam::123456789012:role/admin",
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "test-sa", Namespace: "test-namespace"}}
+			if tt.roleArn != "" {
+				sa.Annotations = map[string]string{awsRoleArnKey: tt.roleArn}
+			}
+			mw := &mutatingWebhook{k8sClient: fake.NewSimpleClientset(), policy: tt.policy}
+			result, err := mw.serviceAccountValidator(context.TODO(), &whmodel.AdmissionReview{Namespace: "test-namespace"}, sa)
+			if err != nil {
+				t.Fatalf("mutatingWebhook.serviceAccountValidator() error = %v", err)
+			}
+			if result.Valid != tt.want {
+				t.Errorf("mutatingWebhook.serviceAccountValidator() valid = %v, want %v (%s)", result.Valid, tt.want, result.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// Pod annotation keys; gtoken containers CPU and memory requests and limits
+	cpuRequestAnnotationKey    = "gtoken.doit-intl.com/cpu-request"
+	memoryRequestAnnotationKey = "gtoken.doit-intl.com/memory-request"
+	cpuLimitAnnotationKey      = "gtoken.doit-intl.com/cpu-limit"
+	memoryLimitAnnotationKey   = "gtoken.doit-intl.com/memory-limit"
+
+	// gtoken containers resource profiles
+	defaultProfile   = "default"
+	autopilotProfile = "autopilot"
+
+	// non-root user and group to run gtoken containers (gtoken image does not define user)
+	nobodyID = 65534
+)
+
+// resourceProfile holds gtoken containers CPU and memory requests and limits
+type resourceProfile struct {
+	requestsCPU    string
+	requestsMemory string
+	limitsCPU      string
+	limitsMemory   string
+}
+
+var resourceProfiles = map[string]resourceProfile{
+	defaultProfile: {requestsCPU: "5m", requestsMemory: "10Mi", limitsCPU: "20m", limitsMemory: "50Mi"},
+	// GKE Autopilot minimum container resources; Autopilot sets limits equal to requests
+	autopilotProfile: {requestsCPU: "50m", requestsMemory: "52Mi", limitsCPU: "50m", limitsMemory: "52Mi"},
+}
+
+// newResources returns gtoken containers resources for profile, overridden with non-empty profile values
+func newResources(profile string, override resourceProfile) (corev1.ResourceRequirements, error) {
+	values, ok := resourceProfiles[profile]
+	if !ok {
+		return corev1.ResourceRequirements{}, fmt.Errorf("unsupported resource profile %q: use %s or %s", profile, defaultProfile, autopilotProfile)
+	}
+	if override.requestsCPU != "" {
+		values.requestsCPU = override.requestsCPU
+	}
+	if override.requestsMemory != "" {
+		values.requestsMemory = override.requestsMemory
+	}
+	if override.limitsCPU != "" {
+		values.limitsCPU = override.limitsCPU
+	}
+	if override.limitsMemory != "" {
+		values.limitsMemory = override.limitsMemory
+	}
+	resources := corev1.ResourceRequirements{Requests: corev1.ResourceList{}, Limits: corev1.ResourceList{}}
+	for _, r := range []struct {
+		list  corev1.ResourceList
+		name  corev1.ResourceName
+		value string
+	}{
+		{resources.Requests, corev1.ResourceCPU, values.requestsCPU},
+		{resources.Requests, corev1.ResourceMemory, values.requestsMemory},
+		{resources.Limits, corev1.ResourceCPU, values.limitsCPU},
+		{resources.Limits, corev1.ResourceMemory, values.limitsMemory},
+	} {
+		quantity, err := resource.ParseQuantity(r.value)
+		if err != nil {
+			return corev1.ResourceRequirements{}, fmt.Errorf("invalid %s quantity %q: %s", r.name, r.value, err.Error())
+		}
+		r.list[r.name] = quantity
+	}
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		if request, limit := resources.Requests[name], resources.Limits[name]; request.Cmp(limit) > 0 {
+			return corev1.ResourceRequirements{}, fmt.Errorf("%s request %s exceeds limit %s", name, request.String(), limit.String())
+		}
+	}
+	return resources, nil
+}
+
+// podResources returns gtoken containers resources overridden with Pod annotations; a request annotation
+// above the webhook limit raises the limit, and a limit annotation below the request is ignored with a warning
+func podResources(pod *corev1.Pod, resources corev1.ResourceRequirements) corev1.ResourceRequirements {
+	defaults := resources
+	resources = *resources.DeepCopy()
+	for _, r := range []struct {
+		name       corev1.ResourceName
+		requestKey string
+		limitKey   string
+	}{
+		{corev1.ResourceCPU, cpuRequestAnnotationKey, cpuLimitAnnotationKey},
+		{corev1.ResourceMemory, memoryRequestAnnotationKey, memoryLimitAnnotationKey},
+	} {
+		request, hasRequest := annotationQuantity(pod, r.requestKey)
+		limit, hasLimit := annotationQuantity(pod, r.limitKey)
+		if hasRequest {
+			setQuantity(&resources.Requests, r.name, request)
+		}
+		if hasLimit {
+			setQuantity(&resources.Limits, r.name, limit)
+		}
+		request, limit = resources.Requests[r.name], resources.Limits[r.name]
+		if _, ok := resources.Limits[r.name]; !ok || request.Cmp(limit) <= 0 {
+			continue
+		}
+		if !hasLimit {
+			logger.WithField("request", request.String()).Infof("raising %s limit to match %s annotation", r.name, r.requestKey)
+			setQuantity(&resources.Limits, r.name, request)
+			continue
+		}
+		logger.WithFields(log.Fields{"request": request.String(), "limit": limit.String()}).
+			Warnf("ignoring %s request and limit annotations: request exceeds limit", r.name)
+		restoreQuantity(resources.Requests, defaults.Requests, r.name)
+		restoreQuantity(resources.Limits, defaults.Limits, r.name)
+	}
+	return resources
+}
+
+// annotationQuantity returns the quantity set with Pod annotation key, ignoring invalid values
+func annotationQuantity(pod *corev1.Pod, key string) (resource.Quantity, bool) {
+	value, ok := pod.GetAnnotations()[key]
+	if !ok {
+		return resource.Quantity{}, false
+	}
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		logger.WithField("value", value).Warnf("ignoring invalid %s annotation", key)
+		return resource.Quantity{}, false
+	}
+	return quantity, true
+}
+
+// setQuantity sets list name resource to quantity, creating list if needed
+func setQuantity(list *corev1.ResourceList, name corev1.ResourceName, quantity resource.Quantity) {
+	if *list == nil {
+		*list = corev1.ResourceList{}
+	}
+	(*list)[name] = quantity
+}
+
+// restoreQuantity resets list name resource to its value in defaults
+func restoreQuantity(list, defaults corev1.ResourceList, name corev1.ResourceName) {
+	if quantity, ok := defaults[name]; ok {
+		list[name] = quantity.DeepCopy()
+		return
+	}
+	delete(list, name)
+}
+
+// restrictedSecurityContext returns gtoken containers security context compliant with Pod Security "restricted" profile
+func restrictedSecurityContext() *corev1.SecurityContext {
+	var nobody int64 = nobodyID
+	runAsNonRoot := true
+	readOnlyRootFilesystem := true
+	allowPrivilegeEscalation := false
+	return &corev1.SecurityContext{
+		RunAsUser:                &nobody,
+		RunAsGroup:               &nobody,
+		RunAsNonRoot:             &runAsNonRoot,
+		ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+		AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+		Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+		SeccompProfile:           &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+	}
+}
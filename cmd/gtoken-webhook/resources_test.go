@@ -0,0 +1,135 @@
+package main
+
+import (
+	"testing"
+
+	cmp "github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestResources(t *testing.T) corev1.ResourceRequirements {
+	resources, err := newResources(defaultProfile, resourceProfile{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resources
+}
+
+func Test_newResources(t *testing.T) {
+	tests := []struct {
+		name     string
+		profile  string
+		override resourceProfile
+		want     resourceProfile
+		wantErr  bool
+	}{
+		{
+			name:    "default",
+			profile: defaultProfile,
+			want:    resourceProfile{requestsCPU: "5m", requestsMemory: "10Mi", limitsCPU: "20m", limitsMemory: "50Mi"},
+		},
+		{
+			name:    "autopilot",
+			profile: autopilotProfile,
+			want:    resourceProfile{requestsCPU: "50m", requestsMemory: "52Mi", limitsCPU: "50m", limitsMemory: "52Mi"},
+		},
+		{
+			name:     "override",
+			profile:  defaultProfile,
+			override: resourceProfile{requestsCPU: "10m", limitsMemory: "64Mi"},
+			want:     resourceProfile{requestsCPU: "10m", requestsMemory: "10Mi", limitsCPU: "20m", limitsMemory: "64Mi"},
+		},
+		{name: "unknown profile", profile: "large", wantErr: true},
+		{name: "invalid quantity", profile: defaultProfile, override: resourceProfile{limitsCPU: "lots"}, wantErr: true},
+		{name: "request exceeds profile limit", profile: defaultProfile, override: resourceProfile{requestsCPU: "100m"}, wantErr: true},
+		{name: "limit below profile request", profile: defaultProfile, override: resourceProfile{limitsMemory: "8Mi"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newResources(tt.profile, tt.override)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newResources() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			want := corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse(tt.want.requestsCPU),
+					corev1.ResourceMemory: resource.MustParse(tt.want.requestsMemory),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse(tt.want.limitsCPU),
+					corev1.ResourceMemory: resource.MustParse(tt.want.limitsMemory),
+				},
+			}
+			if !cmp.Equal(got, want) {
+				t.Errorf("newResources() = diff %v", cmp.Diff(got, want))
+			}
+		})
+	}
+}
+
+func Test_podResources(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        resourceProfile
+	}{
+		{
+			name:        "no annotations",
+			annotations: map[string]string{},
+			want:        resourceProfile{requestsCPU: "5m", requestsMemory: "10Mi", limitsCPU: "20m", limitsMemory: "50Mi"},
+		},
+		{
+			name: "override and ignore invalid quantity",
+			annotations: map[string]string{
+				cpuRequestAnnotationKey:  "10m",
+				memoryLimitAnnotationKey: "128Mi",
+				cpuLimitAnnotationKey:    "a lot",
+			},
+			want: resourceProfile{requestsCPU: "10m", requestsMemory: "10Mi", limitsCPU: "20m", limitsMemory: "128Mi"},
+		},
+		{
+			name:        "request above webhook limit raises limit",
+			annotations: map[string]string{cpuRequestAnnotationKey: "50m", memoryRequestAnnotationKey: "64Mi"},
+			want:        resourceProfile{requestsCPU: "50m", requestsMemory: "64Mi", limitsCPU: "50m", limitsMemory: "64Mi"},
+		},
+		{
+			name:        "request above annotated limit is ignored",
+			annotations: map[string]string{cpuRequestAnnotationKey: "50m", cpuLimitAnnotationKey: "30m", memoryLimitAnnotationKey: "64Mi"},
+			want:        resourceProfile{requestsCPU: "5m", requestsMemory: "10Mi", limitsCPU: "20m", limitsMemory: "64Mi"},
+		},
+		{
+			name:        "limit below webhook request is ignored",
+			annotations: map[string]string{memoryLimitAnnotationKey: "8Mi"},
+			want:        resourceProfile{requestsCPU: "5m", requestsMemory: "10Mi", limitsCPU: "20m", limitsMemory: "50Mi"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaults := newTestResources(t)
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+			got := podResources(pod, defaults)
+			want := corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse(tt.want.requestsCPU),
+					corev1.ResourceMemory: resource.MustParse(tt.want.requestsMemory),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse(tt.want.limitsCPU),
+					corev1.ResourceMemory: resource.MustParse(tt.want.limitsMemory),
+				},
+			}
+			if !cmp.Equal(got, want) {
+				t.Errorf("podResources() = diff %v", cmp.Diff(got, want))
+			}
+			// webhook defaults are not modified
+			if !cmp.Equal(defaults, newTestResources(t)) {
+				t.Errorf("podResources() modified default resources")
+			}
+		})
+	}
+}
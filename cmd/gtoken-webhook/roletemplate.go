@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// Namespace annotation key; set to "true" to derive AWS Role ARN from role ARN template for Service Accounts without AWS Role ARN
+	roleArnTemplateAnnotationKey = "gtoken.doit-intl.com/role-arn-template"
+	// namespace account map key for default AWS account
+	defaultAccountKey = "*"
+)
+
+// roleArnTemplateData is available in the role ARN template
+type roleArnTemplateData struct {
+	// cluster name (--cluster-name flag)
+	ClusterName string
+	// AWS account ID mapped to Pod namespace
+	AccountID string
+	// Pod namespace
+	Namespace string
+	// Pod Service Account name
+	ServiceAccount string
+	// Service Account labels
+	Labels map[string]string
+	// Namespace labels
+	NamespaceLabels map[string]string
+}
+
+// roleArnTemplate derives AWS Role ARN from naming convention
+type roleArnTemplate struct {
+	template    *template.Template
+	clusterName string
+	// namespace -> AWS account ID
+	accounts map[string]string
+}
+
+// newRoleArnTemplate creates role ARN template; namespaceAccounts are namespace=account pairs
+func newRoleArnTemplate(text, clusterName string, namespaceAccounts []string) (*roleArnTemplate, error) {
+	t, err := template.New("role-arn").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	accounts := make(map[string]string)
+	for _, pair := range namespaceAccounts {
+		ns, account, ok := strings.Cut(pair, "=")
+		if !ok || ns == "" || account == "" {
+			return nil, fmt.Errorf("invalid namespace account mapping %q, expected namespace=account", pair)
+		}
+		accounts[strings.TrimSpace(ns)] = strings.TrimSpace(account)
+	}
+	return &roleArnTemplate{template: t, clusterName: clusterName, accounts: accounts}, nil
+}
+
+// optedIn returns true if Namespace opted in to role ARN template
+func optedIn(namespace *corev1.Namespace) bool {
+	value, ok := namespace.GetAnnotations()[roleArnTemplateAnnotationKey]
+	if !ok {
+		return false
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		logger.WithField("value", value).Warnf("ignoring invalid %s annotation", roleArnTemplateAnnotationKey)
+		return false
+	}
+	return enabled
+}
+
+// roleArn returns AWS Role ARN for Service Account in Namespace
+func (t *roleArnTemplate) roleArn(sa *corev1.ServiceAccount, namespace *corev1.Namespace) (string, error) {
+	account, ok := t.accounts[namespace.Name]
+	if !ok {
+		account = t.accounts[defaultAccountKey]
+	}
+	var buf bytes.Buffer
+	err := t.template.Execute(&buf, roleArnTemplateData{
+		ClusterName:     t.clusterName,
+		AccountID:       account,
+		Namespace:       namespace.Name,
+		ServiceAccount:  sa.Name,
+		Labels:          sa.Labels,
+		NamespaceLabels: namespace.Labels,
+	})
+	if err != nil {
+		return "", err
+	}
+	roleArn := buf.String()
+	if err := validateRoleArn(roleArn); err != nil {
+		return "", err
+	}
+	return roleArn, nil
+}
+
+// derive AWS Role ARN from role ARN template, if enabled and Namespace opted in; empty, if not derived
+func (mw *mutatingWebhook) deriveRoleArn(sa *corev1.ServiceAccount, namespace *corev1.Namespace) string {
+	if mw.roleArnTemplate == nil || !optedIn(namespace) {
+		return ""
+	}
+	roleArn, err := mw.roleArnTemplate.roleArn(sa, namespace)
+	if err != nil {
+		logger.WithFields(log.Fields{"service account": sa.Name, "namespace": namespace.Name}).
+			WithError(err).Warn("failed to derive AWS Role ARN from template")
+		return ""
+	}
+	return roleArn
+}
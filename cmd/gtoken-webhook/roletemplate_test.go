@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	whmodel "github.com/slok/kubewebhook/v2/pkg/model"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+const testRoleArnTemplate = "arn:aws:iam::{{.AccountID}}:role/gke-{{.ClusterName}}-{{.Namespace}}-{{.ServiceAccount}}"
+
+func Test_newRoleArnTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		accounts []string
+		wantErr  bool
+	}{
+		{name: "valid", text: testRoleArnTemplate, accounts: []string{"data=123456789012", "*=210987654321"}},
+		{name: "invalid template", text: "arn:aws:iam::{{.AccountID", wantErr: true},
+		{name: "invalid account mapping", text: testRoleArnTemplate, accounts: []string{"data"}, wantErr: true},
+		{name: "empty account", text: testRoleArnTemplate, accounts: []string{"data="}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newRoleArnTemplate(tt.text, "prod", tt.accounts); (err != nil) != tt.wantErr {
+				t.Errorf("newRoleArnTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+//nolint:funlen
+func Test_mutatingWebhook_mutatePod_roleArnTemplate(t *testing.T) {
+	const saRoleArn = "arn:aws:iam::123456789012:role/sa-role"
+	tests := []struct {
+		name          string
+		namespace     string
+		nsAnnotations map[string]string
+		saAnnotations map[string]string
+		accounts      []string
+		wantRoleArn   string
+	}{
+		{
+			name:          "derived role",
+			namespace:     "data",
+			nsAnnotations: map[string]string{roleArnTemplateAnnotationKey: "true"},
+			accounts:      []string{"data=123456789012"},
+			wantRoleArn:   "arn:aws:iam::123456789012:role/gke-prod-data-test-sa",
+		},
+		{
+			name:          "default account",
+			namespace:     "web",
+			nsAnnotations: map[string]string{roleArnTemplateAnnotationKey: "true"},
+			accounts:      []string{"data=123456789012", "*=210987654321"},
+			wantRoleArn:   "arn:aws:iam::210987654321:role/gke-prod-web-test-sa",
+		},
+		{
+			name:        "namespace not opted in",
+			namespace:   "data",
+			accounts:    []string{"data=123456789012"},
+			wantRoleArn: "",
+		},
+		{
+			name:          "explicit annotation wins",
+			namespace:     "data",
+			nsAnnotations: map[string]string{roleArnTemplateAnnotationKey: "true"},
+			saAnnotations: map[string]string{awsRoleArnKey: saRoleArn},
+			accounts:      []string{"data=123456789012"},
+			wantRoleArn:   saRoleArn,
+		},
+		{
+			name:          "no account for namespace",
+			namespace:     "web",
+			nsAnnotations: map[string]string{roleArnTemplateAnnotationKey: "true"},
+			accounts:      []string{"data=123456789012"},
+			wantRoleArn:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roleArnTemplate, err := newRoleArnTemplate(testRoleArnTemplate, "prod", tt.accounts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: tt.namespace, Annotations: tt.nsAnnotations}}
+			sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "test-sa", Namespace: tt.namespace, Annotations: tt.saAnnotations}}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pod"},
+				Spec: corev1.PodSpec{
+					Containers:         []corev1.Container{{Name: "app"}},
+					ServiceAccountName: "test-sa",
+				},
+			}
+			mw := &mutatingWebhook{
+				k8sClient:       fake.NewSimpleClientset(ns, sa),
+				volumeName:      tokenVolumeName,
+				volumePath:      tokenVolumePath,
+				tokenFile:       tokenFileName,
+				roleArnTemplate: roleArnTemplate,
+			}
+			if _, err := mw.mutatePod(context.TODO(), pod, &whmodel.AdmissionReview{Namespace: tt.namespace}); err != nil {
+				t.Fatalf("mutatingWebhook.mutatePod() error = %v", err)
+			}
+			if got := getRoleArnEnv(pod); got != tt.wantRoleArn {
+				t.Errorf("mutatingWebhook.mutatePod() role ARN = %v, want %v", got, tt.wantRoleArn)
+			}
+		})
+	}
+}
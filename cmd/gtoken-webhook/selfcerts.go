@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// self-managed certificates Secret keys
+	caCertKey = "ca.crt"
+	caKeyKey  = "ca.key"
+
+	// self-managed CA validity
+	caValidity = 10 * 365 * 24 * time.Hour
+	// default self-managed serving certificate validity
+	defaultCertValidity = 365 * 24 * time.Hour
+	// default time before certificate expiration to rotate it
+	defaultCertRotateBefore = 30 * 24 * time.Hour
+	// default interval to check self-managed certificates and webhook configurations
+	defaultCertCheckInterval = time.Minute
+)
+
+// certManager generates and rotates self-managed CA and serving certificate, stored in Secret,
+// and patches caBundle of webhook configurations
+type certManager struct {
+	k8sClient   kubernetes.Interface
+	namespace   string
+	secretName  string
+	serviceName string
+	// mutating and validating webhook configuration names (validating webhook configuration is optional)
+	mutatingWebhookConfig   string
+	validatingWebhookConfig string
+	certValidity            time.Duration
+	rotateBefore            time.Duration
+}
+
+// dnsNames returns webhook Service DNS names
+func (m *certManager) dnsNames() []string {
+	return []string{
+		m.serviceName,
+		fmt.Sprintf("%s.%s", m.serviceName, m.namespace),
+		fmt.Sprintf("%s.%s.svc", m.serviceName, m.namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", m.serviceName, m.namespace),
+	}
+}
+
+// generateCert generates ECDSA key and certificate signed by CA (self-signed CA certificate, if ca is nil)
+func generateCert(template, ca *x509.Certificate, caKey *ecdsa.PrivateKey) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	if ca == nil {
+		ca, caKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), nil
+}
+
+// parseCert parses PEM encoded certificate
+func parseCert(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, errors.New("failed to decode PEM certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// parseKey parses PEM encoded ECDSA private key
+func parseKey(keyPEM []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("failed to decode PEM private key")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// expiring returns true if certificate expires within rotation period
+func (m *certManager) expiring(cert *x509.Certificate) bool {
+	return time.Now().Add(m.rotateBefore).After(cert.NotAfter)
+}
+
+// newCA generates CA certificate and key
+func (m *certManager) newCA() (certPEM, keyPEM []byte, err error) {
+	return generateCert(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: fmt.Sprintf("%s-ca", m.serviceName)},
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil, nil)
+}
+
+// newServingCert generates webhook serving certificate and key signed by CA
+func (m *certManager) newServingCert(caCertPEM, caKeyPEM []byte) (certPEM, keyPEM []byte, err error) {
+	ca, err := parseCert(caCertPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	caKey, err := parseKey(caKeyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	return generateCert(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: m.dnsNames()[2]},
+		DNSNames:    m.dnsNames(),
+		NotAfter:    time.Now().Add(m.certValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+}
+
+// certsData returns Secret data with valid CA and serving certificate, generating new ones if missing or expiring;
+// returns replaced CA certificate on CA rotation
+func (m *certManager) certsData(data map[string][]byte) (map[string][]byte, []byte, error) {
+	var previousCA []byte
+	ca, err := parseCert(data[caCertKey])
+	if err == nil {
+		_, err = parseKey(data[caKeyKey])
+	}
+	if err != nil || m.expiring(ca) {
+		logger.Info("generating self-managed CA certificate")
+		if err == nil {
+			previousCA = data[caCertKey]
+		}
+		caCertPEM, caKeyPEM, err := m.newCA()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to generate CA certificate")
+		}
+		data = map[string][]byte{caCertKey: caCertPEM, caKeyKey: caKeyPEM}
+		if ca, err = parseCert(caCertPEM); err != nil {
+			return nil, nil, err
+		}
+	}
+	cert, err := parseCert(data[corev1.TLSCertKey])
+	if err == nil {
+		err = cert.CheckSignatureFrom(ca)
+	}
+	if err != nil || m.expiring(cert) {
+		logger.Info("generating self-managed serving certificate")
+		certPEM, keyPEM, err := m.newServingCert(data[caCertKey], data[caKeyKey])
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to generate serving certificate")
+		}
+		data = map[string][]byte{caCertKey: data[caCertKey], caKeyKey: data[caKeyKey], corev1.TLSCertKey: certPEM, corev1.TLSPrivateKeyKey: keyPEM}
+	}
+	return data, previousCA, nil
+}
+
+// patchCABundle sets caBundle of webhook configurations, if it does not contain CA certificate
+func (m *certManager) patchCABundle(ctx context.Context, caBundle, caCertPEM []byte) error {
+	admission := m.k8sClient.AdmissionregistrationV1()
+	mutating, err := admission.MutatingWebhookConfigurations().Get(ctx, m.mutatingWebhookConfig, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to get mutating webhook configuration %s", m.mutatingWebhookConfig)
+	}
+	var patched bool
+	for i := range mutating.Webhooks {
+		if !bytes.Contains(mutating.Webhooks[i].ClientConfig.CABundle, caCertPEM) {
+			mutating.Webhooks[i].ClientConfig.CABundle = caBundle
+			patched = true
+		}
+	}
+	if patched {
+		if _, err = admission.MutatingWebhookConfigurations().Update(ctx, mutating, metav1.UpdateOptions{}); err != nil {
+			return errors.Wrapf(err, "failed to update mutating webhook configuration %s", m.mutatingWebhookConfig)
+		}
+		logger.WithField("config", m.mutatingWebhookConfig).Info("patched mutating webhook configuration caBundle")
+	}
+	if m.validatingWebhookConfig == "" {
+		return nil
+	}
+	validating, err := admission.ValidatingWebhookConfigurations().Get(ctx, m.validatingWebhookConfig, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		logger.WithField("config", m.validatingWebhookConfig).Debug("validating webhook configuration not found")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to get validating webhook configuration %s", m.validatingWebhookConfig)
+	}
+	patched = false
+	for i := range validating.Webhooks {
+		if !bytes.Contains(validating.Webhooks[i].ClientConfig.CABundle, caCertPEM) {
+			validating.Webhooks[i].ClientConfig.CABundle = caBundle
+			patched = true
+		}
+	}
+	if patched {
+		if _, err = admission.ValidatingWebhookConfigurations().Update(ctx, validating, metav1.UpdateOptions{}); err != nil {
+			return errors.Wrapf(err, "failed to update validating webhook configuration %s", m.validatingWebhookConfig)
+		}
+		logger.WithField("config", m.validatingWebhookConfig).Info("patched validating webhook configuration caBundle")
+	}
+	return nil
+}
+
+// rotate generates missing or expiring certificates, stores them in Secret and patches caBundle of webhook configurations
+func (m *certManager) rotate(ctx context.Context) error {
+	secrets := m.k8sClient.CoreV1().Secrets(m.namespace)
+	secret, err := secrets.Get(ctx, m.secretName, metav1.GetOptions{})
+	exists := err == nil
+	if k8serrors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: m.secretName, Namespace: m.namespace, Labels: map[string]string{"app": "gtoken-webhook"}},
+			Type:       corev1.SecretTypeOpaque,
+		}
+	} else if err != nil {
+		return errors.Wrapf(err, "failed to get secret %s/%s", m.namespace, m.secretName)
+	}
+	data, previousCA, err := m.certsData(secret.Data)
+	if err != nil {
+		return err
+	}
+	// trust both new and previous CA, until all replicas load new serving certificate
+	if err = m.patchCABundle(ctx, append(append([]byte{}, data[caCertKey]...), previousCA...), data[caCertKey]); err != nil {
+		return err
+	}
+	if exists && bytes.Equal(secret.Data[corev1.TLSCertKey], data[corev1.TLSCertKey]) &&
+		bytes.Equal(secret.Data[caCertKey], data[caCertKey]) {
+		return nil
+	}
+	secret.Data = data
+	if !exists {
+		_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+	} else {
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to store certificates in secret %s/%s", m.namespace, m.secretName)
+	}
+	logger.WithField("secret", m.secretName).Info("stored self-managed certificates")
+	return nil
+}
+
+// load serving certificate from Secret into certificate provider
+func (m *certManager) load(ctx context.Context, provider *certProvider) error {
+	secret, err := m.k8sClient.CoreV1().Secrets(m.namespace).Get(ctx, m.secretName, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to get secret %s/%s", m.namespace, m.secretName)
+	}
+	_, err = provider.update(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	return err
+}
+
+// run loads serving certificate from Secret into certificate provider on every replica and rotates certificates
+// on elected leader replica, until context is canceled
+func (m *certManager) run(ctx context.Context, identity string, provider *certProvider, interval time.Duration) {
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := m.load(ctx, provider); err != nil {
+			logger.WithError(err).Warn("failed to load self-managed certificate")
+		}
+	}, interval)
+	config := leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Name: m.secretName, Namespace: m.namespace},
+			Client:     m.k8sClient.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+		},
+		ReleaseOnCancel: true,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				logger.WithField("identity", identity).Info("leading self-managed certificates rotation")
+				wait.UntilWithContext(ctx, func(ctx context.Context) {
+					if err := m.rotate(ctx); err != nil {
+						logger.WithError(err).Error("failed to rotate self-managed certificates")
+						return
+					}
+					// load new certificate without waiting for next sync
+					if err := m.load(ctx, provider); err != nil {
+						logger.WithError(err).Warn("failed to load self-managed certificate")
+					}
+				}, interval)
+			},
+			OnStoppedLeading: func() {
+				logger.WithField("identity", identity).Info("stopped leading self-managed certificates rotation")
+			},
+		},
+	}
+	// run for leadership again after losing it
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, config)
+	}
+}
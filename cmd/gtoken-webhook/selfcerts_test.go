@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestCertManager(t *testing.T) (*certManager, kubernetes.Interface) {
+	client := fake.NewSimpleClientset(
+		&admissionv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "mutating-gtoken-webhook-cfg"},
+			Webhooks:   []admissionv1.MutatingWebhook{{Name: "gtoken.doit-intl.com"}},
+		},
+		&admissionv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "validating-gtoken-webhook-cfg"},
+			Webhooks:   []admissionv1.ValidatingWebhook{{Name: "validate.gtoken.doit-intl.com"}, {Name: "validate-serviceaccounts.gtoken.doit-intl.com"}},
+		})
+	return &certManager{
+		k8sClient:               client,
+		namespace:               "gtoken",
+		secretName:              "gtoken-webhook-certs",
+		serviceName:             "gtoken-webhook-svc",
+		mutatingWebhookConfig:   "mutating-gtoken-webhook-cfg",
+		validatingWebhookConfig: "validating-gtoken-webhook-cfg",
+		certValidity:            defaultCertValidity,
+		rotateBefore:            defaultCertRotateBefore,
+	}, client
+}
+
+// caBundles returns caBundle of all webhooks
+func caBundles(t *testing.T, client kubernetes.Interface) [][]byte {
+	admission := client.AdmissionregistrationV1()
+	mutating, err := admission.MutatingWebhookConfigurations().Get(context.TODO(), "mutating-gtoken-webhook-cfg", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	validating, err := admission.ValidatingWebhookConfigurations().Get(context.TODO(), "validating-gtoken-webhook-cfg", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundles [][]byte
+	for _, webhook := range mutating.Webhooks {
+		bundles = append(bundles, webhook.ClientConfig.CABundle)
+	}
+	for _, webhook := range validating.Webhooks {
+		bundles = append(bundles, webhook.ClientConfig.CABundle)
+	}
+	return bundles
+}
+
+func getCertsSecret(t *testing.T, client kubernetes.Interface) *corev1.Secret {
+	secret, err := client.CoreV1().Secrets("gtoken").Get(context.TODO(), "gtoken-webhook-certs", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+//nolint:funlen
+func Test_certManager_rotate(t *testing.T) {
+	m, client := newTestCertManager(t)
+	// generate certificates
+	if err := m.rotate(context.TODO()); err != nil {
+		t.Fatalf("certManager.rotate() error = %v", err)
+	}
+	secret := getCertsSecret(t, client)
+	for _, bundle := range caBundles(t, client) {
+		if !bytes.Equal(bundle, secret.Data[caCertKey]) {
+			t.Errorf("certManager.rotate() caBundle = %s, want CA certificate", bundle)
+		}
+	}
+	// serving certificate is valid for webhook Service
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(secret.Data[caCertKey])
+	cert, err := parseCert(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cert.Verify(x509.VerifyOptions{DNSName: "gtoken-webhook-svc.gtoken.svc", Roots: pool}); err != nil {
+		t.Errorf("certManager.rotate() serving certificate is not valid: %v", err)
+	}
+	if _, err = tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]); err != nil {
+		t.Errorf("certManager.rotate() invalid key pair: %v", err)
+	}
+
+	// valid certificates are kept
+	if err = m.rotate(context.TODO()); err != nil {
+		t.Fatalf("certManager.rotate() error = %v", err)
+	}
+	if kept := getCertsSecret(t, client); !bytes.Equal(kept.Data[corev1.TLSCertKey], secret.Data[corev1.TLSCertKey]) {
+		t.Error("certManager.rotate() rotated valid serving certificate")
+	}
+
+	// expiring serving certificate is rotated with the same CA
+	m.rotateBefore = m.certValidity + time.Hour
+	if err = m.rotate(context.TODO()); err != nil {
+		t.Fatalf("certManager.rotate() error = %v", err)
+	}
+	rotated := getCertsSecret(t, client)
+	if bytes.Equal(rotated.Data[corev1.TLSCertKey], secret.Data[corev1.TLSCertKey]) {
+		t.Error("certManager.rotate() did not rotate expiring serving certificate")
+	}
+	if !bytes.Equal(rotated.Data[caCertKey], secret.Data[caCertKey]) {
+		t.Error("certManager.rotate() rotated valid CA certificate")
+	}
+
+	// expiring CA is rotated and caBundle trusts both CAs
+	m.rotateBefore = caValidity + time.Hour
+	m.certValidity = caValidity + 2*time.Hour
+	if err = m.rotate(context.TODO()); err != nil {
+		t.Fatalf("certManager.rotate() error = %v", err)
+	}
+	rotated = getCertsSecret(t, client)
+	if bytes.Equal(rotated.Data[caCertKey], secret.Data[caCertKey]) {
+		t.Error("certManager.rotate() did not rotate expiring CA certificate")
+	}
+	for _, bundle := range caBundles(t, client) {
+		if !bytes.Contains(bundle, rotated.Data[caCertKey]) || !bytes.Contains(bundle, secret.Data[caCertKey]) {
+			t.Error("certManager.rotate() caBundle does not contain new and previous CA certificates")
+		}
+	}
+}
+
+func Test_certManager_load(t *testing.T) {
+	m, _ := newTestCertManager(t)
+	provider := &certProvider{}
+	if _, err := provider.GetCertificate(&tls.ClientHelloInfo{}); err == nil {
+		t.Error("certProvider.GetCertificate() expected error before certificate is loaded")
+	}
+	if err := m.load(context.TODO(), provider); err == nil {
+		t.Error("certManager.load() expected error for missing secret")
+	}
+	if err := m.rotate(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.load(context.TODO(), provider); err != nil {
+		t.Fatalf("certManager.load() error = %v", err)
+	}
+	if cert, err := provider.GetCertificate(&tls.ClientHelloInfo{}); err != nil || cert == nil {
+		t.Errorf("certProvider.GetCertificate() = %v, %v", cert, err)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"regexp"
+	"strings"
+	"text/template"
+
+	whmodel "github.com/slok/kubewebhook/v2/pkg/model"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// default AWS role session name template
+	defaultSessionNameTemplate = "{{.Namespace}}-{{.PodName}}-{{.RequestUID}}"
+	// AWS STS role session name length limits
+	minSessionNameLength = 2
+	maxSessionNameLength = 64
+	// length of random session name suffix and RequestUID
+	sessionNameSuffixLength = 8
+	// characters used in random strings
+	randomChars = "abcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// characters not allowed in AWS STS role session name
+var invalidSessionNameChars = regexp.MustCompile(`[^\w+=,.@-]`)
+
+// default session namer, used when webhook is not configured with a custom template
+var defaultSessionNamer = mustSessionNamer(defaultSessionNameTemplate, false)
+
+// sessionNameData is available in the session name template
+type sessionNameData struct {
+	// Pod namespace
+	Namespace string
+	// Pod name or generateName prefix (without trailing dash), for Pods created by controllers
+	PodName string
+	// Pod generateName
+	GenerateName string
+	// Pod Service Account name
+	ServiceAccount string
+	// first 8 characters of admission request UID; not the Pod UID, which is assigned after admission
+	RequestUID string
+	// 8 crypto-random characters
+	Random string
+	// Pod labels
+	Labels map[string]string
+}
+
+// sessionNamer generates AWS role session names from template at admission time
+type sessionNamer struct {
+	template     *template.Template
+	randomSuffix bool
+}
+
+func newSessionNamer(text string, randomSuffix bool) (*sessionNamer, error) {
+	t, err := template.New("session-name").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionNamer{template: t, randomSuffix: randomSuffix}, nil
+}
+
+func mustSessionNamer(text string, randomSuffix bool) *sessionNamer {
+	namer, err := newSessionNamer(text, randomSuffix)
+	if err != nil {
+		panic(err)
+	}
+	return namer
+}
+
+// name returns sanitized AWS role session name for the Pod
+func (s *sessionNamer) name(pod *corev1.Pod, ar *whmodel.AdmissionReview) string {
+	podName := pod.Name
+	if podName == "" {
+		podName = strings.TrimSuffix(pod.GenerateName, "-")
+	}
+	requestUID := strings.ReplaceAll(ar.ID, "-", "")
+	if len(requestUID) > sessionNameSuffixLength {
+		requestUID = requestUID[:sessionNameSuffixLength]
+	} else if requestUID == "" {
+		requestUID = randomString(sessionNameSuffixLength)
+	}
+	data := sessionNameData{
+		Namespace:      ar.Namespace,
+		PodName:        podName,
+		GenerateName:   pod.GenerateName,
+		ServiceAccount: pod.Spec.ServiceAccountName,
+		RequestUID:     requestUID,
+		Random:         randomString(sessionNameSuffixLength),
+		Labels:         pod.Labels,
+	}
+	var buf bytes.Buffer
+	if err := s.template.Execute(&buf, data); err != nil {
+		logger.WithError(err).Warn("failed to execute session name template")
+		buf.Reset()
+	}
+	name := invalidSessionNameChars.ReplaceAllString(buf.String(), "-")
+	maxLength := maxSessionNameLength
+	if s.randomSuffix {
+		maxLength -= sessionNameSuffixLength + 1
+	}
+	if len(name) > maxLength {
+		// shorten name prefix, keeping unique suffix (RequestUID in default template)
+		keep := sessionNameSuffixLength + 1
+		name = name[:maxLength-keep] + name[len(name)-keep:]
+	}
+	if s.randomSuffix {
+		name += "-" + randomString(sessionNameSuffixLength)
+	}
+	if len(name) < minSessionNameLength {
+		name = "gtoken-webhook-" + randomString(sessionNameSuffixLength)
+	}
+	return name
+}
+
+// Generate a crypto-random string of a-z0-9 chars with len = l
+func randomString(l int) string {
+	if testMode {
+		return strings.Repeat("0", l)
+	}
+	b := make([]byte, l)
+	if _, err := rand.Read(b); err != nil {
+		logger.WithError(err).Error("failed to generate random string")
+	}
+	for i := range b {
+		b[i] = randomChars[int(b[i])%len(randomChars)]
+	}
+	return string(b)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	whmodel "github.com/slok/kubewebhook/v2/pkg/model"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//nolint:funlen
+func Test_sessionNamer_name(t *testing.T) {
+	tests := []struct {
+		name         string
+		template     string
+		randomSuffix bool
+		pod          *corev1.Pod
+		ar           *whmodel.AdmissionReview
+		want         string
+	}{
+		{
+			name:     "default template",
+			template: defaultSessionNameTemplate,
+			pod:      &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "my-pod"}},
+			ar:       &whmodel.AdmissionReview{ID: "a1b2c3d4-e5f6-7890", Namespace: "default"},
+			want:     "default-my-pod-a1b2c3d4",
+		},
+		{
+			name:     "generate name",
+			template: defaultSessionNameTemplate,
+			pod:      &corev1.Pod{ObjectMeta: metav1.ObjectMeta{GenerateName: "my-deployment-5d8f9c-"}},
+			ar:       &whmodel.AdmissionReview{ID: "a1b2c3d4-e5f6-7890", Namespace: "default"},
+			want:     "default-my-deployment-5d8f9c-a1b2c3d4",
+		},
+		{
+			name:     "service account and labels",
+			template: "{{.ServiceAccount}}@{{.Labels.app}}",
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "my-pod", Labels: map[string]string{"app": "web"}},
+				Spec:       corev1.PodSpec{ServiceAccountName: "my-sa"},
+			},
+			ar:   &whmodel.AdmissionReview{Namespace: "default"},
+			want: "my-sa@web",
+		},
+		{
+			name:     "sanitize invalid characters",
+			template: "{{.Namespace}}/{{.PodName}}:{{.Labels.version}}",
+			pod:      &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "my-pod", Labels: map[string]string{"version": "v1 beta"}}},
+			ar:       &whmodel.AdmissionReview{Namespace: "default"},
+			want:     "default-my-pod-v1-beta",
+		},
+		{
+			name:     "shorten long name",
+			template: "{{.PodName}}",
+			pod:      &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: strings.Repeat("a", 100)}},
+			ar:       &whmodel.AdmissionReview{Namespace: "default"},
+			want:     strings.Repeat("a", maxSessionNameLength),
+		},
+		{
+			name:     "shorten long name keeping request UID",
+			template: defaultSessionNameTemplate,
+			pod:      &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: strings.Repeat("p", 63)}},
+			ar:       &whmodel.AdmissionReview{ID: "a1b2c3d4-e5f6-7890", Namespace: strings.Repeat("n", 63)},
+			want:     strings.Repeat("n", 55) + "-a1b2c3d4",
+		},
+		{
+			name:         "random suffix",
+			template:     defaultSessionNameTemplate,
+			randomSuffix: true,
+			pod:          &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: strings.Repeat("p", 63)}},
+			ar:           &whmodel.AdmissionReview{ID: "a1b2c3d4-e5f6-7890", Namespace: "default"},
+			want:         "default-" + strings.Repeat("p", 38) + "-a1b2c3d4-00000000",
+		},
+		{
+			name:     "fallback for short name",
+			template: "{{.Labels.missing}}",
+			pod:      &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "my-pod"}},
+			ar:       &whmodel.AdmissionReview{Namespace: "default"},
+			want:     "gtoken-webhook-00000000",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			namer, err := newSessionNamer(tt.template, tt.randomSuffix)
+			if err != nil {
+				t.Fatalf("newSessionNamer() error = %v", err)
+			}
+			if got := namer.name(tt.pod, tt.ar); got != tt.want {
+				t.Errorf("sessionNamer.name() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newSessionNamer_invalid(t *testing.T) {
+	if _, err := newSessionNamer("{{.Namespace", false); err == nil {
+		t.Error("newSessionNamer() expected error for invalid template")
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Pod, Service Account or Namespace annotation key; ID token audience
+const audienceAnnotationKey = "gtoken.doit-intl.com/audience"
+
+// get K8s Namespace; returns empty Namespace, if Namespace does not exist
+func (mw *mutatingWebhook) getNamespace(ctx context.Context, name string) (*corev1.Namespace, error) {
+	var namespace *corev1.Namespace
+	var err error
+	if mw.cache != nil {
+		namespace, err = mw.cache.getNamespace(ctx, name)
+	} else {
+		namespace, err = mw.k8sClient.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	}
+	if k8serrors.IsNotFound(err) {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get namespace %s", name)
+	}
+	return namespace, nil
+}
+
+// annotationValues returns annotation values of objects, ordered by objects precedence
+func annotationValues(key string, objs ...metav1.Object) []string {
+	var values []string
+	for _, obj := range objs {
+		if value, ok := obj.GetAnnotations()[key]; ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// get ID token audience: Pod > GTokenBinding > Service Account > Namespace annotation (gtoken default, if empty)
+func podAudience(pod *corev1.Pod, sa *corev1.ServiceAccount, binding *gtokenBinding, namespace *corev1.Namespace) string {
+	if values := annotationValues(audienceAnnotationKey, pod); len(values) > 0 {
+		return values[0]
+	}
+	if binding != nil && binding.Spec.Audience != "" {
+		return binding.Spec.Audience
+	}
+	if values := annotationValues(audienceAnnotationKey, sa, namespace); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
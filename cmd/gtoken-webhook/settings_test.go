@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	whmodel "github.com/slok/kubewebhook/v2/pkg/model"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+//nolint:funlen
+func Test_mutatingWebhook_mutatePod_namespaceDefaults(t *testing.T) {
+	const (
+		nsRoleArn  = "arn:aws:iam::123456789012:role/namespace-role"
+		saRoleArn  = "arn:aws:iam::123456789012:role/sa-role"
+		podRoleArn = "arn:aws:iam::123456789012:role/pod-role"
+	)
+	nsAnnotations := map[string]string{
+		roleArnAnnotationKey:       nsRoleArn,
+		audienceAnnotationKey:      "namespace-audience",
+		awsRegionAnnotationKey:     "eu-west-1",
+		injectionModeAnnotationKey: string(initOnlyMode),
+	}
+	tests := []struct {
+		name           string
+		nsAnnotations  map[string]string
+		saAnnotations  map[string]string
+		podAnnotations map[string]string
+		wantRoleArn    string
+		wantAudience   string
+		wantRegion     string
+		wantSidekick   bool
+	}{
+		{
+			name:          "namespace defaults",
+			nsAnnotations: nsAnnotations,
+			wantRoleArn:   nsRoleArn,
+			wantAudience:  "--audience=namespace-audience",
+			wantRegion:    "eu-west-1",
+		},
+		{
+			name:          "service account overrides namespace",
+			nsAnnotations: nsAnnotations,
+			saAnnotations: map[string]string{
+				awsRoleArnKey:              saRoleArn,
+				audienceAnnotationKey:      "sa-audience",
+				awsRegionAnnotationKey:     "us-east-2",
+				injectionModeAnnotationKey: string(sidecarMode),
+			},
+			wantRoleArn:  saRoleArn,
+			wantAudience: "--audience=sa-audience",
+			wantRegion:   "us-east-2",
+			wantSidekick: true,
+		},
+		{
+			name:          "pod overrides service account and namespace",
+			nsAnnotations: nsAnnotations,
+			saAnnotations: map[string]string{awsRoleArnKey: saRoleArn, audienceAnnotationKey: "sa-audience"},
+			podAnnotations: map[string]string{
+				roleArnAnnotationKey:       podRoleArn,
+				audienceAnnotationKey:      "pod-audience",
+				awsRegionAnnotationKey:     "ap-south-1",
+				injectionModeAnnotationKey: string(sidecarMode),
+			},
+			wantRoleArn:  podRoleArn,
+			wantAudience: "--audience=pod-audience",
+			wantRegion:   "ap-south-1",
+			wantSidekick: true,
+		},
+		{
+			name:          "invalid namespace mode",
+			nsAnnotations: map[string]string{roleArnAnnotationKey: nsRoleArn, injectionModeAnnotationKey: "daemon"},
+			wantRoleArn:   nsRoleArn,
+			wantSidekick:  true,
+		},
+		{
+			name:         "no namespace annotations",
+			wantSidekick: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-namespace", Annotations: tt.nsAnnotations}}
+			sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "test-sa", Namespace: "test-namespace", Annotations: tt.saAnnotations}}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Annotations: tt.podAnnotations},
+				Spec: corev1.PodSpec{
+					Containers:         []corev1.Container{{Name: "app"}},
+					ServiceAccountName: "test-sa",
+				},
+			}
+			mw := &mutatingWebhook{
+				k8sClient:          fake.NewSimpleClientset(ns, sa),
+				volumeName:         tokenVolumeName,
+				volumePath:         tokenVolumePath,
+				tokenFile:          tokenFileName,
+				podRoleArnOverride: true,
+			}
+			if _, err := mw.mutatePod(context.TODO(), pod, &whmodel.AdmissionReview{Namespace: "test-namespace"}); err != nil {
+				t.Fatalf("mutatingWebhook.mutatePod() error = %v", err)
+			}
+			if got := getRoleArnEnv(pod); got != tt.wantRoleArn {
+				t.Errorf("mutatingWebhook.mutatePod() role ARN = %v, want %v", got, tt.wantRoleArn)
+			}
+			if tt.wantRoleArn == "" {
+				return
+			}
+			env := make(map[string]string)
+			for _, e := range pod.Spec.Containers[0].Env {
+				env[e.Name] = e.Value
+			}
+			if env[awsRegion] != tt.wantRegion {
+				t.Errorf("mutatingWebhook.mutatePod() region = %v, want %v", env[awsRegion], tt.wantRegion)
+			}
+			var audience string
+			for _, arg := range pod.Spec.InitContainers[0].Command {
+				if strings.HasPrefix(arg, "--audience=") {
+					audience = arg
+				}
+			}
+			if audience != tt.wantAudience {
+				t.Errorf("mutatingWebhook.mutatePod() audience = %v, want %v", audience, tt.wantAudience)
+			}
+			if sidekick := pod.Spec.Containers[len(pod.Spec.Containers)-1].Name == sidekickContainerName; sidekick != tt.wantSidekick {
+				t.Errorf("mutatingWebhook.mutatePod() sidekick = %v, want %v", sidekick, tt.wantSidekick)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	whmodel "github.com/slok/kubewebhook/v2/pkg/model"
+	"github.com/slok/kubewebhook/v2/pkg/webhook/validating"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Pod annotation key; set to "true" to reject Pod admission without AWS credentials injection
+const requiredAnnotationKey = "gtoken.doit-intl.com/required"
+
+// Kubernetes version with admission webhook matchConditions enabled by default
+var matchConditionsVersion = version.MustParseGeneric("1.28.0")
+
+// check if Pod requires AWS credentials injection
+func requiredPod(pod *corev1.Pod) bool {
+	value, ok := pod.GetAnnotations()[requiredAnnotationKey]
+	if !ok {
+		return false
+	}
+	required, err := strconv.ParseBool(value)
+	if err != nil {
+		logger.WithField("value", value).Warnf("ignoring invalid %s annotation", requiredAnnotationKey)
+		return false
+	}
+	return required
+}
+
+// podValidator rejects Pods with AWS role denied by policy and Pods that require AWS credentials, but were not injected
+// by mutating webhook (webhook is down or failed and mutating webhook failurePolicy is Ignore)
+func (mw *mutatingWebhook) podValidator(_ context.Context, ar *whmodel.AdmissionReview, obj metav1.Object) (*validating.ValidatorResult, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return &validating.ValidatorResult{Valid: true}, nil
+	}
+	if pod.GetAnnotations()[statusAnnotationKey] == deniedStatus {
+		logger.WithField("pod", pod.Name).Warn("rejecting pod with AWS role denied by policy")
+		return &validating.ValidatorResult{
+			Valid:   false,
+			Message: fmt.Sprintf("gtoken-webhook denied pod: %s", pod.GetAnnotations()[deniedReasonAnnotationKey]),
+		}, nil
+	}
+	// status annotation can be set by Pod author, check injected gtoken init container instead
+	if !requiredPod(pod) || mw.hasTokenInitContainer(pod) {
+		return &validating.ValidatorResult{Valid: true}, nil
+	}
+	// mutating webhook does not inject dry-run requests, so injection cannot be verified
+	if ar.DryRun {
+		logger.WithField("pod", pod.Name).Debug("admitting dry-run pod that requires AWS credentials")
+		return &validating.ValidatorResult{Valid: true}, nil
+	}
+	logger.WithField("pod", pod.Name).Warn("rejecting pod that requires AWS credentials, but was not injected")
+	return &validating.ValidatorResult{
+		Valid:   false,
+		Message: fmt.Sprintf("pod requires AWS credentials (%s annotation), but was not injected by gtoken-webhook", requiredAnnotationKey),
+	}, nil
+}
+
+// serviceAccountValidator rejects Service Accounts with invalid AWS Role ARN or AWS role not allowed by policy
+func (mw *mutatingWebhook) serviceAccountValidator(ctx context.Context, ar *whmodel.AdmissionReview, obj metav1.Object) (*validating.ValidatorResult, error) {
+	sa, ok := obj.(*corev1.ServiceAccount)
+	if !ok {
+		return &validating.ValidatorResult{Valid: true}, nil
+	}
+	roleArn, ok := sa.GetAnnotations()[awsRoleArnKey]
+	if !ok {
+		return &validating.ValidatorResult{Valid: true}, nil
+	}
+	err := validateRoleArn(roleArn)
+	if err == nil && mw.policy != nil {
+		var namespace *corev1.Namespace
+		namespace, err = mw.getNamespace(ctx, ar.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		err = mw.policy.check(roleArn, sa, namespace)
+	}
+	if err != nil {
+		logger.WithFields(log.Fields{"service account": sa.Name, "namespace": ar.Namespace}).WithError(err).Warn("rejecting service account")
+		return &validating.ValidatorResult{
+			Valid:   false,
+			Message: fmt.Sprintf("invalid %s annotation: %s", awsRoleArnKey, err.Error()),
+		}, nil
+	}
+	return &validating.ValidatorResult{Valid: true}, nil
+}
+
+// checkValidatingWebhooks returns error if validating webhook configuration sends every Pod or Service Account to
+// gtoken-webhook with failurePolicy Fail: matchConditions are dropped by Kubernetes clusters older than 1.28
+func checkValidatingWebhooks(ctx context.Context, k8sClient kubernetes.Interface, name string) error {
+	if name == "" {
+		return nil
+	}
+	config, err := k8sClient.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to get validating webhook configuration %s", name)
+	}
+	for _, webhook := range config.Webhooks {
+		// failurePolicy defaults to Fail
+		failClosed := webhook.FailurePolicy == nil || *webhook.FailurePolicy == admissionv1.Fail
+		if failClosed && len(webhook.MatchConditions) == 0 {
+			return fmt.Errorf("validating webhook %s has failurePolicy Fail without matchConditions (Kubernetes %s+ required)", webhook.Name, matchConditionsVersion)
+		}
+	}
+	return nil
+}
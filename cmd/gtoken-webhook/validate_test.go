@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	whmodel "github.com/slok/kubewebhook/v2/pkg/model"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_mutatingWebhook_podValidator(t *testing.T) {
+	injectedSpec := corev1.PodSpec{
+		InitContainers: []corev1.Container{{
+			Name:         initContainerName,
+			VolumeMounts: []corev1.VolumeMount{{Name: tokenVolumeName, MountPath: tokenVolumePath}},
+		}},
+		Containers: []corev1.Container{{Name: "app"}},
+		Volumes:    []corev1.Volume{{Name: tokenVolumeName}},
+	}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		spec        corev1.PodSpec
+		dryRun      bool
+		want        bool
+	}{
+		{
+			name: "not required",
+			want: true,
+		},
+		{
+			name:        "required and injected",
+			annotations: map[string]string{requiredAnnotationKey: "true", statusAnnotationKey: injectedStatus},
+			spec:        injectedSpec,
+			want:        true,
+		},
+		{
+			name:        "required with status annotation set by pod author",
+			annotations: map[string]string{requiredAnnotationKey: "true", statusAnnotationKey: injectedStatus},
+			want:        false,
+		},
+		{
+			name:        "required with gtoken init container without token volume",
+			annotations: map[string]string{requiredAnnotationKey: "true"},
+			spec:        corev1.PodSpec{InitContainers: injectedSpec.InitContainers, Containers: injectedSpec.Containers},
+			want:        false,
+		},
+		{
+			name:        "required and not injected",
+			annotations: map[string]string{requiredAnnotationKey: "true"},
+			want:        false,
+		},
+		{
+			name:        "required and not injected dry-run",
+			annotations: map[string]string{requiredAnnotationKey: "true"},
+			dryRun:      true,
+			want:        true,
+		},
+		{
+			name:        "denied by policy dry-run",
+			annotations: map[string]string{requiredAnnotationKey: "true", statusAnnotationKey: deniedStatus, deniedReasonAnnotationKey: "not allowed"},
+			dryRun:      true,
+			want:        false,
+		},
+		{
+			name:        "not required explicitly",
+			annotations: map[string]string{requiredAnnotationKey: "false"},
+			want:        true,
+		},
+		{
+			name:        "invalid required annotation",
+			annotations: map[string]string{requiredAnnotationKey: "yes please"},
+			want:        true,
+		},
+		{
+			name:        "denied by policy",
+			annotations: map[string]string{statusAnnotationKey: deniedStatus, deniedReasonAnnotationKey: "not allowed"},
+			want:        false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := tt.spec
+			if spec.Containers == nil {
+				spec.Containers = []corev1.Container{{Name: "app"}}
+			}
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Annotations: tt.annotations}, Spec: spec}
+			mw := &mutatingWebhook{volumeName: tokenVolumeName, volumePath: tokenVolumePath}
+			result, err := mw.podValidator(context.TODO(), &whmodel.AdmissionReview{Namespace: "test-namespace", DryRun: tt.dryRun}, pod)
+			if err != nil {
+				t.Fatalf("mutatingWebhook.podValidator() error = %v", err)
+			}
+			if result.Valid != tt.want {
+				t.Errorf("mutatingWebhook.podValidator() valid = %v, want %v (%s)", result.Valid, tt.want, result.Message)
+			}
+		})
+	}
+}
+
+func Test_checkValidatingWebhooks(t *testing.T) {
+	ignore := admissionv1.Ignore
+	fail := admissionv1.Fail
+	matchConditions := []admissionv1.MatchCondition{{Name: "aws-role", Expression: "true"}}
+	tests := []struct {
+		name     string
+		webhooks []admissionv1.ValidatingWebhook
+		wantErr  bool
+	}{
+		{
+			name:     "scoped with matchConditions",
+			webhooks: []admissionv1.ValidatingWebhook{{Name: "validate.gtoken.doit-intl.com", FailurePolicy: &fail, MatchConditions: matchConditions}},
+		},
+		{
+			name:     "matchConditions dropped by cluster",
+			webhooks: []admissionv1.ValidatingWebhook{{Name: "validate.gtoken.doit-intl.com", FailurePolicy: &fail, MatchConditions: matchConditions}, {Name: "validate-serviceaccounts.gtoken.doit-intl.com", FailurePolicy: &fail}},
+			wantErr:  true,
+		},
+		{
+			name:     "default failure policy",
+			webhooks: []admissionv1.ValidatingWebhook{{Name: "validate.gtoken.doit-intl.com"}},
+			wantErr:  true,
+		},
+		{
+			name:     "ignore failure policy",
+			webhooks: []admissionv1.ValidatingWebhook{{Name: "validate.gtoken.doit-intl.com", FailurePolicy: &ignore}},
+		},
+		{name: "not installed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objects []runtime.Object
+			if tt.webhooks != nil {
+				objects = append(objects, &admissionv1.ValidatingWebhookConfiguration{
+					ObjectMeta: metav1.ObjectMeta{Name: "validating-gtoken-webhook-cfg"},
+					Webhooks:   tt.webhooks,
+				})
+			}
+			err := checkValidatingWebhooks(context.TODO(), fake.NewSimpleClientset(objects...), "validating-gtoken-webhook-cfg")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkValidatingWebhooks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/doitintl/gtoken/internal/gcp"
+
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// default AWS STS endpoint
+	defaultSTSEndpoint = "https://sts.amazonaws.com"
+	// session name used for STS AssumeRoleWithWebIdentity dry run
+	doctorSessionName = "gtoken-doctor"
+)
+
+// errSkipped is returned by a doctor check when its prerequisites failed
+var errSkipped = errors.New("skipped")
+
+// doctorWarning is returned by a doctor check that found a problem gtoken works around; it does not fail doctor
+type doctorWarning struct {
+	err error
+}
+
+func (w *doctorWarning) Error() string { return w.err.Error() }
+
+func (w *doctorWarning) Unwrap() error { return w.err }
+
+// doctorState is shared by doctor checks; each check fills the values for later checks
+type doctorState struct {
+	sa          gcp.ServiceAccountInfo
+	idToken     gcp.Token
+	audience    string
+	roleArn     string
+	stsEndpoint string
+	httpClient  *http.Client
+
+	email          string
+	serviceAccount string
+	token          string
+	subject        string
+}
+
+// doctorCheck is a single diagnostics step
+type doctorCheck struct {
+	name string
+	run  func(ctx context.Context, s *doctorState) (string, error)
+	hint func(s *doctorState, err error) string
+}
+
+func checkMetadata(_ context.Context, _ *doctorState) (string, error) {
+	latency, err := gcp.PingMetadata()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("reachable in %s", latency.Round(time.Millisecond)), nil
+}
+
+func checkServiceAccount(_ context.Context, s *doctorState) (string, error) {
+	email, err := s.sa.GetEmail()
+	if err != nil {
+		return "", err
+	}
+	s.email = email
+	scopes, err := gcp.GetScopes()
+	if err != nil {
+		return "", err
+	}
+	for _, scope := range scopes {
+		if strings.Contains(scope, "cloud-platform") {
+			return fmt.Sprintf("%s; scopes: %s", email, strings.Join(scopes, ", ")), nil
+		}
+	}
+	return "", fmt.Errorf("%s: cloud-platform scope is missing; scopes: %s", email, strings.Join(scopes, ", "))
+}
+
+func checkCredentialsType(ctx context.Context, _ *doctorState) (string, error) {
+	credsType, err := gcp.GetCredentialsType(ctx)
+	if err != nil {
+		return "", err
+	}
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		return fmt.Sprintf("%s (from GOOGLE_APPLICATION_CREDENTIALS=%s)", credsType, path), nil
+	}
+	return credsType, nil
+}
+
+func checkServiceAccountID(ctx context.Context, s *doctorState) (string, error) {
+	id, err := s.sa.GetID(ctx)
+	if err != nil {
+		if s.email == "" {
+			return "", err
+		}
+		// gtoken falls back to Service Account email
+		s.serviceAccount = s.email
+		return "", &doctorWarning{err: err}
+	}
+	s.serviceAccount = id
+	return id, nil
+}
+
+func checkGenerateIDToken(ctx context.Context, s *doctorState) (string, error) {
+	if s.serviceAccount == "" {
+		return "", errSkipped
+	}
+	token, err := s.idToken.Generate(ctx, s.serviceAccount)
+	if err != nil {
+		return "", err
+	}
+	s.token = token
+	return fmt.Sprintf("generated ID token for %s", s.serviceAccount), nil
+}
+
+func checkTokenClaims(_ context.Context, s *doctorState) (string, error) {
+	if s.token == "" {
+		return "", errSkipped
+	}
+	claims, err := gcp.ParseClaims(s.token)
+	if err != nil {
+		return "", err
+	}
+	s.subject, _ = claims["sub"].(string)
+	if aud, _ := claims["aud"].(string); aud != s.audience {
+		return "", fmt.Errorf("unexpected audience: %s, expected: %s", aud, s.audience)
+	}
+	if iss, _ := claims["iss"].(string); iss != "https://accounts.google.com" {
+		return "", fmt.Errorf("unexpected issuer: %s", iss)
+	}
+	duration, err := s.idToken.GetDuration(s.token)
+	if err != nil {
+		return "", err
+	}
+	if duration <= 0 {
+		return "", fmt.Errorf("token is expired")
+	}
+	email, _ := claims["email"].(string)
+	return fmt.Sprintf("aud: %s; sub: %s; email: %s; expires in %s", s.audience, s.subject, email, duration.Round(time.Second)), nil
+}
+
+// stsResponse is AWS STS AssumeRoleWithWebIdentity response (or error response)
+type stsResponse struct {
+	AssumedRoleArn string `xml:"AssumeRoleWithWebIdentityResult>AssumedRoleUser>Arn"`
+	ErrorCode      string `xml:"Error>Code"`
+	ErrorMessage   string `xml:"Error>Message"`
+}
+
+// assumeRoleWithWebIdentity calls AWS STS AssumeRoleWithWebIdentity (unsigned request) and discards credentials
+func assumeRoleWithWebIdentity(ctx context.Context, client *http.Client, endpoint, roleArn, token string) (string, error) {
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {roleArn},
+		"RoleSessionName":  {doctorSessionName},
+		"WebIdentityToken": {token},
+		"DurationSeconds":  {"900"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create STS request: %s", err.Error())
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call STS: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read STS response: %s", err.Error())
+	}
+	var sts stsResponse
+	if err = xml.Unmarshal(body, &sts); err != nil {
+		return "", fmt.Errorf("failed to parse STS response (%s): %s", resp.Status, err.Error())
+	}
+	if sts.ErrorCode != "" {
+		return "", fmt.Errorf("%s: %s", sts.ErrorCode, sts.ErrorMessage)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected STS response status: %s", resp.Status)
+	}
+	return sts.AssumedRoleArn, nil
+}
+
+func checkAssumeRole(ctx context.Context, s *doctorState) (string, error) {
+	if s.roleArn == "" || s.token == "" {
+		return "", errSkipped
+	}
+	arn, err := assumeRoleWithWebIdentity(ctx, s.httpClient, s.stsEndpoint, s.roleArn, s.token)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("assumed role: %s", arn), nil
+}
+
+var doctorChecks = []doctorCheck{
+	{
+		name: "metadata server",
+		run:  checkMetadata,
+		hint: func(*doctorState, error) string {
+			return "enable GKE Workload Identity on the cluster and run the node pool with GKE_METADATA workload metadata mode"
+		},
+	},
+	{
+		name: "default service account",
+		run:  checkServiceAccount,
+		hint: func(s *doctorState, _ error) string {
+			if s.email != "" {
+				return "GKE Workload Identity always reports cloud-platform scope; check the node pool scopes"
+			}
+			return "annotate Kubernetes Service Account with iam.gke.io/gcp-service-account=<GSA email> and " +
+				"grant the roles/iam.workloadIdentityUser role to the Kubernetes Service Account on the GSA"
+		},
+	},
+	{
+		name: "application default credentials",
+		run:  checkCredentialsType,
+		hint: func(*doctorState, error) string {
+			return "unset GOOGLE_APPLICATION_CREDENTIALS to use GKE Workload Identity credentials"
+		},
+	},
+	{
+		name: "service account unique ID",
+		run:  checkServiceAccountID,
+		hint: func(s *doctorState, _ error) string {
+			if s.serviceAccount != "" {
+				return fmt.Sprintf("gtoken will fall back to Service Account email: %s", s.serviceAccount)
+			}
+			return "make sure GSA exists and Application Default Credentials are available"
+		},
+	},
+	{
+		name: "GenerateIdToken permission",
+		run:  checkGenerateIDToken,
+		hint: func(s *doctorState, err error) string {
+			if strings.Contains(err.Error(), "Error 403") {
+				return fmt.Sprintf("grant roles/iam.serviceAccountTokenCreator role: gcloud iam service-accounts add-iam-policy-binding %s "+
+					"--member serviceAccount:%s --role roles/iam.serviceAccountTokenCreator", s.email, s.email)
+			}
+			return "enable IAM Service Account Credentials API (iamcredentials.googleapis.com) in the project"
+		},
+	},
+	{
+		name: "ID token claims",
+		run:  checkTokenClaims,
+		hint: func(s *doctorState, _ error) string {
+			return fmt.Sprintf("use the same audience in gtoken and AWS role trust policy (expected: %s)", s.audience)
+		},
+	},
+	{
+		name: "STS AssumeRoleWithWebIdentity",
+		run:  checkAssumeRole,
+		hint: func(s *doctorState, err error) string {
+			if strings.HasPrefix(err.Error(), "AccessDenied") || strings.HasPrefix(err.Error(), "InvalidIdentityToken") {
+				return fmt.Sprintf("AWS role trust policy must allow sts:AssumeRoleWithWebIdentity for Federated accounts.google.com "+
+					"principal with condition accounts.google.com:sub=%s", s.subject)
+			}
+			return "check AWS role ARN and STS endpoint"
+		},
+	},
+}
+
+// runDoctor runs all checks in order and prints pass/warn/fail with remediation hints
+func runDoctor(ctx context.Context, w io.Writer, checks []doctorCheck, s *doctorState) error {
+	var failed int
+	for _, check := range checks {
+		detail, err := check.run(ctx, s)
+		var warning *doctorWarning
+		switch {
+		case errors.Is(err, errSkipped):
+			fmt.Fprintf(w, "[SKIP] %s\n", check.name)
+		case err != nil:
+			status := "WARN"
+			if !errors.As(err, &warning) {
+				status = "FAIL"
+				failed++
+			}
+			fmt.Fprintf(w, "[%s] %s: %s\n", status, check.name, err)
+			if check.hint != nil {
+				fmt.Fprintf(w, "       hint: %s\n", check.hint(s, err))
+			}
+		default:
+			fmt.Fprintf(w, "[PASS] %s: %s\n", check.name, detail)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
+
+func doctorCmd(c *cli.Context) error {
+	s := &doctorState{
+		sa:          gcp.NewSaInfo(),
+		idToken:     gcp.NewIDToken(c.String("audience")),
+		audience:    c.String("audience"),
+		roleArn:     c.String("role-arn"),
+		stsEndpoint: c.String("sts-endpoint"),
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+	}
+	return runDoctor(handleSignals(), os.Stdout, doctorChecks, s)
+}
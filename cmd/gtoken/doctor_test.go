@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/doitintl/gtoken/internal/gcp"
+)
+
+func Test_runDoctor(t *testing.T) {
+	pass := func(context.Context, *doctorState) (string, error) { return "ok", nil }
+	fail := func(context.Context, *doctorState) (string, error) { return "", errors.New("failed") }
+	skip := func(context.Context, *doctorState) (string, error) { return "", errSkipped }
+	warn := func(context.Context, *doctorState) (string, error) {
+		return "", &doctorWarning{err: errors.New("degraded")}
+	}
+	hint := func(*doctorState, error) string { return "fix it" }
+	tests := []struct {
+		name    string
+		checks  []doctorCheck
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "all checks pass",
+			checks: []doctorCheck{{name: "first", run: pass}, {name: "second", run: pass}},
+			want:   "[PASS] first: ok\n[PASS] second: ok\n",
+		},
+		{
+			name:    "failed and skipped checks",
+			checks:  []doctorCheck{{name: "first", run: fail, hint: hint}, {name: "second", run: skip}, {name: "third", run: pass}},
+			want:    "[FAIL] first: failed\n       hint: fix it\n[SKIP] second\n[PASS] third: ok\n",
+			wantErr: true,
+		},
+		{
+			name:   "warning does not fail",
+			checks: []doctorCheck{{name: "first", run: warn, hint: hint}, {name: "second", run: pass}},
+			want:   "[WARN] first: degraded\n       hint: fix it\n[PASS] second: ok\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := runDoctor(context.TODO(), &out, tt.checks, &doctorState{}); (err != nil) != tt.wantErr {
+				t.Errorf("runDoctor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if out.String() != tt.want {
+				t.Errorf("runDoctor() output = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkServiceAccountID(t *testing.T) {
+	const email = "test@project.iam.gserviceaccount.com"
+	tests := []struct {
+		name               string
+		id                 string
+		idErr              error
+		email              string
+		wantServiceAccount string
+		wantErr            bool
+		wantWarning        bool
+	}{
+		{name: "unique ID", id: "123", email: email, wantServiceAccount: "123"},
+		{name: "fall back to email", idErr: errors.New("failed"), email: email, wantServiceAccount: email, wantErr: true, wantWarning: true},
+		{name: "no ID and no email", idErr: errors.New("failed"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa := &gcp.MockServiceAccountInfo{}
+			sa.On("GetID", context.TODO()).Return(tt.id, tt.idErr)
+			s := &doctorState{sa: sa, email: tt.email}
+			_, err := checkServiceAccountID(context.TODO(), s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkServiceAccountID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var warning *doctorWarning
+			if errors.As(err, &warning) != tt.wantWarning {
+				t.Errorf("checkServiceAccountID() warning = %v, want %v", err, tt.wantWarning)
+			}
+			if s.serviceAccount != tt.wantServiceAccount {
+				t.Errorf("checkServiceAccountID() service account = %s, want %s", s.serviceAccount, tt.wantServiceAccount)
+			}
+		})
+	}
+}
+
+func Test_checkTokenClaims(t *testing.T) {
+	sign := func(claims jwt.MapClaims) string {
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+		return token
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{
+			name:  "valid claims",
+			token: sign(jwt.MapClaims{"aud": gcp.DefaultAudience, "iss": "https://accounts.google.com", "sub": "123", "exp": exp}),
+		},
+		{
+			name:    "unexpected audience",
+			token:   sign(jwt.MapClaims{"aud": "other", "iss": "https://accounts.google.com", "sub": "123", "exp": exp}),
+			wantErr: true,
+		},
+		{
+			name:    "unexpected issuer",
+			token:   sign(jwt.MapClaims{"aud": gcp.DefaultAudience, "iss": "other", "sub": "123", "exp": exp}),
+			wantErr: true,
+		},
+		{
+			name:    "expired token",
+			token:   sign(jwt.MapClaims{"aud": gcp.DefaultAudience, "iss": "https://accounts.google.com", "sub": "123", "exp": 1}),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &doctorState{idToken: gcp.NewIDToken(gcp.DefaultAudience), audience: gcp.DefaultAudience, token: tt.token}
+			if _, err := checkTokenClaims(context.TODO(), s); (err != nil) != tt.wantErr {
+				t.Errorf("checkTokenClaims() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if s.subject != "123" {
+				t.Errorf("checkTokenClaims() subject = %s, want 123", s.subject)
+			}
+		})
+	}
+}
+
+func Test_assumeRoleWithWebIdentity(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "assume role",
+			status: http.StatusOK,
+			body: `<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <AssumedRoleUser><Arn>arn:aws:sts::123456789012:assumed-role/test/gtoken-doctor</Arn></AssumedRoleUser>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`,
+			want: "arn:aws:sts::123456789012:assumed-role/test/gtoken-doctor",
+		},
+		{
+			name:   "access denied",
+			status: http.StatusForbidden,
+			body: `<ErrorResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <Error><Type>Sender</Type><Code>AccessDenied</Code><Message>Not authorized to perform sts:AssumeRoleWithWebIdentity</Message></Error>
+</ErrorResponse>`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := r.ParseForm(); err != nil || r.Form.Get("RoleArn") != "arn:aws:iam::123456789012:role/test" ||
+					r.Form.Get("WebIdentityToken") != "token" {
+					t.Errorf("unexpected STS request: %v", r.Form)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+			got, err := assumeRoleWithWebIdentity(context.TODO(), srv.Client(), srv.URL, "arn:aws:iam::123456789012:role/test", "token")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("assumeRoleWithWebIdentity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("assumeRoleWithWebIdentity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
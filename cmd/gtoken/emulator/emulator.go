@@ -0,0 +1,364 @@
+// Package emulator implements a fake GCE metadata server and IAM Service Account Credentials API,
+// issuing real RS256 JWTs signed with a local key, for testing gtoken-based workloads offline.
+//
+// Point Google client libraries and gtoken to the emulator with environment variables:
+//
+//	GCE_METADATA_HOST=127.0.0.1:8080
+//	GTOKEN_IAM_CREDENTIALS_ENDPOINT=http://127.0.0.1:8080/
+package emulator
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	mrand "math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// Google ID token issuer
+	googleIssuer = "https://accounts.google.com"
+	// metadata server request header
+	metadataFlavorHeader = "Metadata-Flavor"
+	metadataFlavor       = "Google"
+	// RSA key size
+	keySize = 2048
+
+	metadataPrefix       = "/computeMetadata/v1/"
+	serviceAccountPrefix = metadataPrefix + "instance/service-accounts/"
+	iamCredentialsPrefix = "/v1/projects/-/serviceAccounts/"
+	jwksPath             = "/oauth2/v3/certs"
+	jwkPrefix            = "/service_accounts/v1/metadata/jwk/"
+)
+
+// Config configures emulated Service Account and failure injection knobs
+type Config struct {
+	// ProjectID is the GCP project ID
+	ProjectID string
+	// Email is the default Service Account email
+	Email string
+	// UniqueID is the default Service Account unique ID (ID token sub claim)
+	UniqueID string
+	// Scopes are the default Service Account OAuth2 scopes
+	Scopes []string
+	// TokenLifetime is the lifetime of generated ID and access tokens
+	TokenLifetime time.Duration
+	// Latency is added to every response
+	Latency time.Duration
+	// ErrorRate is a fraction (0..1) of requests failing with ErrorCode
+	ErrorRate float64
+	// ErrorCode is the HTTP status code of injected failures
+	ErrorCode int
+}
+
+// DefaultConfig returns emulator configuration with a test Service Account
+func DefaultConfig() Config {
+	return Config{
+		ProjectID:     "gtoken-emulator",
+		Email:         "gtoken@gtoken-emulator.iam.gserviceaccount.com",
+		UniqueID:      "100000000000000000001",
+		Scopes:        []string{"https://www.googleapis.com/auth/cloud-platform"},
+		TokenLifetime: time.Hour,
+		ErrorCode:     http.StatusServiceUnavailable,
+	}
+}
+
+// Server is a fake metadata server and IAM Service Account Credentials API
+type Server struct {
+	config Config
+	key    *rsa.PrivateKey
+	keyID  string
+	mux    *http.ServeMux
+
+	mu   sync.Mutex
+	rand *mrand.Rand
+}
+
+// New creates emulator server with a new RSA signing key
+func New(config Config) (*Server, error) {
+	if config.TokenLifetime <= 0 {
+		config.TokenLifetime = time.Hour
+	}
+	if config.ErrorCode == 0 {
+		config.ErrorCode = http.StatusServiceUnavailable
+	}
+	key, err := rsa.GenerateKey(rand.Reader, keySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %s", err.Error())
+	}
+	hash := sha256.Sum256(key.PublicKey.N.Bytes())
+	s := &Server{
+		config: config,
+		key:    key,
+		keyID:  hex.EncodeToString(hash[:8]),
+		mux:    http.NewServeMux(),
+		//nolint:gosec
+		rand: mrand.New(mrand.NewSource(time.Now().UnixNano())),
+	}
+	s.mux.HandleFunc("/", s.handleRoot)
+	s.mux.HandleFunc(metadataPrefix, s.handleMetadata)
+	s.mux.HandleFunc(iamCredentialsPrefix, s.handleIAMCredentials)
+	s.mux.HandleFunc(jwksPath, s.handleJWKS)
+	s.mux.HandleFunc(jwkPrefix, s.handleJWKS)
+	return s, nil
+}
+
+// PublicKey returns the public key to verify tokens issued by emulator
+func (s *Server) PublicKey() *rsa.PublicKey {
+	return &s.key.PublicKey
+}
+
+// KeyID returns the signing key ID (JWT kid header)
+func (s *Server) KeyID() string {
+	return s.keyID
+}
+
+// ServeHTTP adds configured latency and injected failures to all requests
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.config.Latency > 0 {
+		select {
+		case <-time.After(s.config.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if s.shouldFail() {
+		writeError(w, s.config.ErrorCode, "injected failure")
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) shouldFail() bool {
+	if s.config.ErrorRate <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rand.Float64() < s.config.ErrorRate
+}
+
+// handleRoot responds to metadata.OnGCE() probe
+func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("not found: %s", r.URL.Path))
+		return
+	}
+	w.Header().Set(metadataFlavorHeader, metadataFlavor)
+	_, _ = w.Write([]byte("computeMetadata/\n"))
+}
+
+func (s *Server) handleMetadata(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(metadataFlavorHeader) != metadataFlavor {
+		writeError(w, http.StatusForbidden, "missing Metadata-Flavor:Google header")
+		return
+	}
+	w.Header().Set(metadataFlavorHeader, metadataFlavor)
+	path := strings.TrimPrefix(r.URL.Path, metadataPrefix)
+	switch path {
+	case "project/project-id":
+		writeText(w, s.config.ProjectID)
+		return
+	case "instance/id":
+		writeText(w, s.config.UniqueID)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, serviceAccountPrefix) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("not found: %s", path))
+		return
+	}
+	// instance/service-accounts/<account>/<attribute>
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, serviceAccountPrefix), "/", 2)
+	if len(parts) != 2 || !s.isServiceAccount(parts[0]) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("not found: %s", path))
+		return
+	}
+	switch parts[1] {
+	case "email":
+		writeText(w, s.config.Email)
+	case "scopes":
+		writeText(w, strings.Join(s.config.Scopes, "\n")+"\n")
+	case "token":
+		writeJSON(w, map[string]interface{}{
+			"access_token": s.accessToken(),
+			"expires_in":   int(s.config.TokenLifetime.Seconds()),
+			"token_type":   "Bearer",
+		})
+	case "identity":
+		audience := r.URL.Query().Get("audience")
+		if audience == "" {
+			writeError(w, http.StatusBadRequest, "missing audience parameter")
+			return
+		}
+		token, err := s.idToken(audience, r.URL.Query().Get("format") == "full")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeText(w, token)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("not found: %s", path))
+	}
+}
+
+func (s *Server) handleIAMCredentials(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("method not allowed: %s", r.Method))
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		writeError(w, http.StatusUnauthorized, "request is missing required authentication credential")
+		return
+	}
+	// /v1/projects/-/serviceAccounts/<account>:<method>
+	i := strings.LastIndex(r.URL.Path, ":")
+	if i < 0 {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("not found: %s", r.URL.Path))
+		return
+	}
+	account, method := strings.TrimPrefix(r.URL.Path[:i], iamCredentialsPrefix), r.URL.Path[i+1:]
+	if !s.isServiceAccount(account) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("service account not found: %s", account))
+		return
+	}
+	var request struct {
+		Audience     string   `json:"audience"`
+		IncludeEmail bool     `json:"includeEmail"`
+		Scope        []string `json:"scope"`
+		Lifetime     string   `json:"lifetime"`
+		Payload      string   `json:"payload"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %s", err.Error()))
+		return
+	}
+	switch method {
+	case "generateIdToken":
+		if request.Audience == "" {
+			writeError(w, http.StatusBadRequest, "audience is required")
+			return
+		}
+		token, err := s.idToken(request.Audience, request.IncludeEmail)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, map[string]string{"token": token})
+	case "generateAccessToken":
+		lifetime := s.config.TokenLifetime
+		if request.Lifetime != "" {
+			d, err := time.ParseDuration(request.Lifetime)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid lifetime: %s", request.Lifetime))
+				return
+			}
+			lifetime = d
+		}
+		writeJSON(w, map[string]string{
+			"accessToken": s.accessToken(),
+			"expireTime":  time.Now().Add(lifetime).UTC().Format(time.RFC3339),
+		})
+	case "signJwt":
+		claims := jwt.MapClaims{}
+		if err := json.Unmarshal([]byte(request.Payload), &claims); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid payload: %s", err.Error()))
+			return
+		}
+		token, err := s.sign(claims)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, map[string]string{"keyId": s.keyID, "signedJwt": token})
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown method: %s", method))
+	}
+}
+
+// handleJWKS serves the public signing key as JSON Web Key Set
+func (s *Server) handleJWKS(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": s.keyID,
+				"n":   base64.RawURLEncoding.EncodeToString(s.key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.PublicKey.E)).Bytes()),
+			},
+		},
+	})
+}
+
+func (s *Server) isServiceAccount(account string) bool {
+	return account == "default" || account == s.config.Email || account == s.config.UniqueID
+}
+
+// idToken issues Google-like ID token for the default Service Account
+func (s *Server) idToken(audience string, includeEmail bool) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": googleIssuer,
+		"aud": audience,
+		"azp": s.config.UniqueID,
+		"sub": s.config.UniqueID,
+		"iat": now.Unix(),
+		"exp": now.Add(s.config.TokenLifetime).Unix(),
+	}
+	if includeEmail {
+		claims["email"] = s.config.Email
+		claims["email_verified"] = true
+	}
+	return s.sign(claims)
+}
+
+func (s *Server) sign(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.keyID
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %s", err.Error())
+	}
+	return signed, nil
+}
+
+// accessToken returns random opaque access token
+func (s *Server) accessToken() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return "ya29.emulator-" + base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeText(w http.ResponseWriter, text string) {
+	w.Header().Set("Content-Type", "application/text")
+	_, _ = w.Write([]byte(text))
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes Google API error response
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+			"status":  strings.ToUpper(strings.ReplaceAll(http.StatusText(code), " ", "_")),
+		},
+	})
+}
@@ -0,0 +1,172 @@
+package emulator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/doitintl/gtoken/internal/gcp"
+)
+
+// setup starts emulator and points metadata and IAM Credentials clients to it
+func setup(t *testing.T, config Config) *Server {
+	emulator, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(emulator)
+	t.Cleanup(srv.Close)
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(srv.URL, "http://"))
+	t.Setenv("GTOKEN_IAM_CREDENTIALS_ENDPOINT", srv.URL+"/")
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	// avoid gcloud well-known credentials file
+	t.Setenv("HOME", t.TempDir())
+	return emulator
+}
+
+func TestEmulator_IDToken(t *testing.T) {
+	config := DefaultConfig()
+	emulator := setup(t, config)
+	ctx := context.TODO()
+
+	email, err := gcp.NewSaInfo().GetEmail()
+	if err != nil {
+		t.Fatalf("GetEmail() error = %v", err)
+	}
+	if email != config.Email {
+		t.Errorf("GetEmail() = %s, want %s", email, config.Email)
+	}
+	idToken := gcp.NewIDToken(gcp.DefaultAudience)
+	token, err := idToken.Generate(ctx, email)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	// verify token signature with emulator public key
+	parsed, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		if token.Header["kid"] != emulator.KeyID() {
+			t.Errorf("unexpected kid: %v", token.Header["kid"])
+		}
+		return emulator.PublicKey(), nil
+	})
+	if err != nil || !parsed.Valid {
+		t.Fatalf("failed to verify ID token: %v", err)
+	}
+	claims := parsed.Claims.(jwt.MapClaims)
+	if claims["aud"] != gcp.DefaultAudience || claims["sub"] != config.UniqueID || claims["email"] != config.Email {
+		t.Errorf("unexpected ID token claims: %v", claims)
+	}
+	duration, err := idToken.GetDuration(token)
+	if err != nil {
+		t.Fatalf("GetDuration() error = %v", err)
+	}
+	if duration <= config.TokenLifetime-time.Minute || duration > config.TokenLifetime {
+		t.Errorf("GetDuration() = %s, want ~%s", duration, config.TokenLifetime)
+	}
+}
+
+func TestEmulator_AccessToken(t *testing.T) {
+	config := DefaultConfig()
+	setup(t, config)
+	accessToken := gcp.NewAccessToken(nil, 10*time.Minute)
+	token, err := accessToken.Generate(context.TODO(), config.Email)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if !strings.HasPrefix(token, "ya29.") {
+		t.Errorf("Generate() = %s, want access token", token)
+	}
+	duration, err := accessToken.GetDuration(token)
+	if err != nil {
+		t.Fatalf("GetDuration() error = %v", err)
+	}
+	if duration <= 9*time.Minute || duration > 10*time.Minute {
+		t.Errorf("GetDuration() = %s, want ~10m", duration)
+	}
+}
+
+func TestEmulator_SignedJWT(t *testing.T) {
+	config := DefaultConfig()
+	emulator := setup(t, config)
+	token, err := gcp.NewSignedJWT(map[string]interface{}{"tenant": "acme"}, time.Hour).Generate(context.TODO(), config.Email)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	parsed, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) {
+		return emulator.PublicKey(), nil
+	})
+	if err != nil {
+		t.Fatalf("failed to verify JWT: %v", err)
+	}
+	claims := parsed.Claims.(jwt.MapClaims)
+	if claims["tenant"] != "acme" || claims["iss"] != config.Email || claims["sub"] != config.Email {
+		t.Errorf("unexpected JWT claims: %v", claims)
+	}
+}
+
+func TestEmulator_Knobs(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     func(*Config)
+		header     bool
+		wantStatus int
+		minLatency time.Duration
+	}{
+		{
+			name:       "metadata",
+			config:     func(*Config) {},
+			header:     true,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "missing metadata header",
+			config:     func(*Config) {},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "injected error",
+			config:     func(c *Config) { c.ErrorRate = 1; c.ErrorCode = http.StatusTooManyRequests },
+			header:     true,
+			wantStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:       "latency",
+			config:     func(c *Config) { c.Latency = 100 * time.Millisecond },
+			header:     true,
+			wantStatus: http.StatusOK,
+			minLatency: 100 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			tt.config(&config)
+			emulator, err := New(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv := httptest.NewServer(emulator)
+			defer srv.Close()
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+"/computeMetadata/v1/instance/service-accounts/default/email", nil)
+			if tt.header {
+				req.Header.Set("Metadata-Flavor", "Google")
+			}
+			start := time.Now()
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if time.Since(start) < tt.minLatency {
+				t.Errorf("latency = %s, want at least %s", time.Since(start), tt.minLatency)
+			}
+		})
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/doitintl/gtoken
 
-go 1.17
+go 1.22
 
 require (
 	cloud.google.com/go/compute v0.1.0
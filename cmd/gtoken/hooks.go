@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// environment variable with token file path, passed to exec hook
+	tokenFileEnv = "GTOKEN_FILE"
+	// default signal sent by signal hook
+	defaultHookSignal = syscall.SIGHUP
+	// /proc filesystem used to find processes by name (requires shareProcessNamespace in K8s Pod)
+	procDir = "/proc"
+)
+
+var hookSignals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// hook is notified after a new token has been written
+type hook interface {
+	fmt.Stringer
+	run(ctx context.Context, file string) error
+}
+
+// hooks runs post-refresh hooks; a nil value runs nothing
+type hooks struct {
+	hooks   []hook
+	timeout time.Duration
+}
+
+// notify runs all hooks in background: hook failures are logged and never block the refresh loop;
+// returned WaitGroup is done when all hooks complete
+func (h *hooks) notify(ctx context.Context, file string) *sync.WaitGroup {
+	wg := &sync.WaitGroup{}
+	if h == nil {
+		return wg
+	}
+	for _, hk := range h.hooks {
+		wg.Add(1)
+		go func(hk hook) {
+			defer wg.Done()
+			cx, cancel := context.WithTimeout(ctx, h.timeout)
+			defer cancel()
+			if err := hk.run(cx, file); err != nil {
+				log.Printf("post-refresh hook %s failed: %s\n", hk, err)
+				return
+			}
+			log.Printf("post-refresh hook %s completed\n", hk)
+		}(hk)
+	}
+	return wg
+}
+
+// wait for hooks started by notify to complete, up to hook timeout
+func (h *hooks) wait(wg *sync.WaitGroup) {
+	if h == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(h.timeout):
+		log.Printf("post-refresh hooks did not complete in %s\n", h.timeout)
+	}
+}
+
+// execHook runs a command with token file path in GTOKEN_FILE environment variable
+type execHook struct {
+	command []string
+}
+
+func (e execHook) String() string {
+	return fmt.Sprintf("exec:%s", strings.Join(e.command, " "))
+}
+
+func (e execHook) run(ctx context.Context, file string) error {
+	//nolint:gosec
+	cmd := exec.CommandContext(ctx, e.command[0], e.command[1:]...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", tokenFileEnv, file))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("command failed: %s; output: %s", err.Error(), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// signalHook sends a signal to all processes with matching name
+type signalHook struct {
+	process string
+	signal  syscall.Signal
+}
+
+func (s signalHook) String() string {
+	return fmt.Sprintf("signal:%s:%s", s.process, s.signal)
+}
+
+func (s signalHook) run(ctx context.Context, _ string) error {
+	pids, err := findProcesses(procDir, s.process)
+	if err != nil {
+		return err
+	}
+	if len(pids) == 0 {
+		return fmt.Errorf("process not found: %s", s.process)
+	}
+	for _, pid := range pids {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		p, err := os.FindProcess(pid)
+		if err != nil {
+			return fmt.Errorf("failed to find process %d: %s", pid, err.Error())
+		}
+		if err = p.Signal(s.signal); err != nil {
+			return fmt.Errorf("failed to send signal to process %d: %s", pid, err.Error())
+		}
+	}
+	return nil
+}
+
+// findProcesses returns PIDs of processes with name (or executable base name) matching the name
+func findProcesses(proc, name string) ([]int, error) {
+	entries, err := os.ReadDir(proc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes: %s", err.Error())
+	}
+	self := os.Getpid()
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		comm, err := os.ReadFile(filepath.Join(proc, entry.Name(), "comm"))
+		if err == nil && strings.TrimSpace(string(comm)) == name {
+			pids = append(pids, pid)
+			continue
+		}
+		// comm is truncated to 15 chars; compare executable name from command line too
+		cmdline, err := os.ReadFile(filepath.Join(proc, entry.Name(), "cmdline"))
+		if err == nil && len(cmdline) > 0 {
+			if filepath.Base(strings.Split(string(cmdline), "\x00")[0]) == name {
+				pids = append(pids, pid)
+			}
+		}
+	}
+	return pids, nil
+}
+
+// httpHook sends POST request with token file path to the URL
+type httpHook struct {
+	url    string
+	client *http.Client
+}
+
+func (h httpHook) String() string {
+	return fmt.Sprintf("http:%s", h.url)
+}
+
+func (h httpHook) run(ctx context.Context, file string) error {
+	body, err := json.Marshal(map[string]string{"file": file})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %s", err.Error())
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %s", err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return nil
+}
+
+// parseSignal parses signal name (HUP, SIGHUP) or number
+func parseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return syscall.Signal(n), nil
+	}
+	if sig, ok := hookSignals[strings.TrimPrefix(strings.ToUpper(s), "SIG")]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unsupported signal: %s", s)
+}
+
+// newHooks creates post-refresh hooks from command line flags
+func newHooks(execs, signals, urls []string, timeout time.Duration) (*hooks, error) {
+	h := &hooks{timeout: timeout}
+	for _, e := range execs {
+		command := strings.Fields(e)
+		if len(command) == 0 {
+			return nil, fmt.Errorf("empty exec hook command")
+		}
+		h.hooks = append(h.hooks, execHook{command: command})
+	}
+	for _, s := range signals {
+		// format: process[:signal]
+		process, name := s, ""
+		if i := strings.LastIndex(s, ":"); i >= 0 {
+			process, name = s[:i], s[i+1:]
+		}
+		if process == "" {
+			return nil, fmt.Errorf("empty signal hook process name: %s", s)
+		}
+		sig := defaultHookSignal
+		if name != "" {
+			var err error
+			if sig, err = parseSignal(name); err != nil {
+				return nil, err
+			}
+		}
+		h.hooks = append(h.hooks, signalHook{process: process, signal: sig})
+	}
+	for _, u := range urls {
+		h.hooks = append(h.hooks, httpHook{url: u, client: &http.Client{}})
+	}
+	return h, nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_newHooks(t *testing.T) {
+	type args struct {
+		execs   []string
+		signals []string
+		urls    []string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "all hooks",
+			args: args{
+				execs:   []string{"/bin/reload --all"},
+				signals: []string{"nginx", "app:USR1", "other:SIGTERM", "num:10"},
+				urls:    []string{"http://localhost:8080/reload"},
+			},
+			want: []string{
+				"exec:/bin/reload --all",
+				"signal:nginx:hangup",
+				"signal:app:user defined signal 1",
+				"signal:other:terminated",
+				"signal:num:user defined signal 1",
+				"http:http://localhost:8080/reload",
+			},
+		},
+		{
+			name:    "empty exec command",
+			args:    args{execs: []string{" "}},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported signal",
+			args:    args{signals: []string{"app:FOO"}},
+			wantErr: true,
+		},
+		{
+			name:    "empty process name",
+			args:    args{signals: []string{":HUP"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newHooks(tt.args.execs, tt.args.signals, tt.args.urls, time.Second)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newHooks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var names []string
+			for _, h := range got.hooks {
+				names = append(names, h.String())
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("newHooks() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func Test_findProcesses(t *testing.T) {
+	proc := t.TempDir()
+	for pid, p := range map[string][2]string{
+		"10": {"nginx\n", "nginx\x00-g\x00daemon off;"},
+		"11": {"long-process-na\n", "/usr/bin/long-process-name\x00--flag"},
+		"12": {"other\n", "other"},
+		"ab": {"nginx\n", "nginx"},
+	} {
+		if err := os.MkdirAll(filepath.Join(proc, pid), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		_ = os.WriteFile(filepath.Join(proc, pid, "comm"), []byte(p[0]), 0o600)
+		_ = os.WriteFile(filepath.Join(proc, pid, "cmdline"), []byte(p[1]), 0o600)
+	}
+	tests := []struct {
+		name    string
+		process string
+		want    []int
+	}{
+		{name: "by comm", process: "nginx", want: []int{10}},
+		{name: "by command line", process: "long-process-name", want: []int{11}},
+		{name: "not found", process: "missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findProcesses(proc, tt.process)
+			if err != nil {
+				t.Fatalf("findProcesses() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findProcesses() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_execHook_run(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	h := execHook{command: []string{"sh", "-c", "echo -n $GTOKEN_FILE > " + out}}
+	if err := h.run(context.TODO(), "/var/run/token"); err != nil {
+		t.Fatalf("execHook.run() error = %v", err)
+	}
+	got, _ := os.ReadFile(out)
+	if string(got) != "/var/run/token" {
+		t.Errorf("execHook.run() env = %s, want %s", got, "/var/run/token")
+	}
+	if err := (execHook{command: []string{"sh", "-c", "exit 1"}}).run(context.TODO(), ""); err == nil {
+		t.Error("execHook.run() expected error")
+	}
+}
+
+func Test_httpHook_run(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "notify", status: http.StatusOK},
+		{name: "server error", status: http.StatusInternalServerError, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost {
+					t.Errorf("unexpected method: %s", r.Method)
+				}
+				_ = json.NewDecoder(r.Body).Decode(&got)
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+			h := httpHook{url: srv.URL, client: srv.Client()}
+			if err := h.run(context.TODO(), "/var/run/token"); (err != nil) != tt.wantErr {
+				t.Errorf("httpHook.run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got["file"] != "/var/run/token" {
+				t.Errorf("httpHook.run() file = %s, want %s", got["file"], "/var/run/token")
+			}
+		})
+	}
+}
+
+// blockingHook waits until hook context is canceled
+type blockingHook struct {
+	done chan struct{}
+}
+
+func (b blockingHook) String() string {
+	return "blocking"
+}
+
+func (b blockingHook) run(ctx context.Context, _ string) error {
+	<-ctx.Done()
+	close(b.done)
+	return ctx.Err()
+}
+
+// slowHook completes after delay, unless hook context is canceled
+type slowHook struct {
+	delay time.Duration
+	done  chan struct{}
+}
+
+func (s slowHook) String() string {
+	return "slow"
+}
+
+func (s slowHook) run(ctx context.Context, _ string) error {
+	select {
+	case <-time.After(s.delay):
+		close(s.done)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func Test_hooks_notify(t *testing.T) {
+	done := make(chan struct{})
+	h := &hooks{
+		hooks:   []hook{blockingHook{done: done}, signalHook{process: "missing", signal: syscall.SIGHUP}},
+		timeout: 100 * time.Millisecond,
+	}
+	start := time.Now()
+	h.notify(context.TODO(), "token")
+	if time.Since(start) > 50*time.Millisecond {
+		t.Error("hooks.notify() blocked")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("hook timeout is not applied")
+	}
+	// nil hooks is a no-op
+	var empty *hooks
+	empty.notify(context.TODO(), "token")
+}
+
+func Test_hooks_wait(t *testing.T) {
+	done := make(chan struct{})
+	h := &hooks{hooks: []hook{slowHook{delay: 100 * time.Millisecond, done: done}}, timeout: 5 * time.Second}
+	h.wait(h.notify(context.TODO(), "token"))
+	select {
+	case <-done:
+	default:
+		t.Error("hooks.wait() returned before hooks completed")
+	}
+	// wait is capped by hook timeout
+	h = &hooks{hooks: []hook{blockingHook{done: make(chan struct{})}}, timeout: 100 * time.Millisecond}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	start := time.Now()
+	h.wait(wg)
+	if time.Since(start) > time.Second {
+		t.Error("hooks.wait() is not capped by hook timeout")
+	}
+	// nil hooks is a no-op
+	var empty *hooks
+	empty.wait(empty.notify(context.TODO(), "token"))
+}
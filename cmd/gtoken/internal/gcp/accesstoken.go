@@ -0,0 +1,73 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"google.golang.org/api/iamcredentials/v1"
+)
+
+const (
+	// default OAuth2 access token scope
+	DefaultAccessTokenScope = "https://www.googleapis.com/auth/cloud-platform"
+)
+
+// AccessToken generates OAuth2 access tokens; access tokens are opaque, so the
+// expiration time of the last generated token is kept to calculate duration
+type AccessToken struct {
+	scopes   []string
+	lifetime time.Duration
+	expiry   time.Time
+}
+
+func NewAccessToken(scopes []string, lifetime time.Duration) Token {
+	if len(scopes) == 0 {
+		scopes = []string{DefaultAccessTokenScope}
+	}
+	return &AccessToken{scopes: scopes, lifetime: lifetime}
+}
+
+// request returns generateAccessToken request with scopes and lifetime (API default, if not set)
+func (t *AccessToken) request() *iamcredentials.GenerateAccessTokenRequest {
+	request := &iamcredentials.GenerateAccessTokenRequest{
+		Scope: t.scopes,
+	}
+	if t.lifetime > 0 {
+		request.Lifetime = fmt.Sprintf("%ds", int64(t.lifetime.Seconds()))
+	}
+	return request
+}
+
+func (t *AccessToken) Generate(ctx context.Context, serviceAccount string) (string, error) {
+	log.Println("generating a new access token")
+	iamCredentialsClient, err := newIAMCredentialsService(ctx)
+	if err != nil {
+		return "", err
+	}
+	generateAccessTokenResponse, err := iamCredentialsClient.Projects.ServiceAccounts.GenerateAccessToken(
+		fmt.Sprintf("projects/-/serviceAccounts/%s", serviceAccount),
+		t.request(),
+	).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate access token: %s", err.Error())
+	}
+	t.expiry, err = time.Parse(time.RFC3339, generateAccessTokenResponse.ExpireTime)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse access token expire time: %s", err.Error())
+	}
+	log.Println("successfully generated access token")
+	return generateAccessTokenResponse.AccessToken, nil
+}
+
+func (t *AccessToken) GetDuration(string) (time.Duration, error) {
+	if t.expiry.IsZero() {
+		return 0, fmt.Errorf("access token expire time is unknown")
+	}
+	return time.Until(t.expiry), nil
+}
+
+func (AccessToken) WriteToFile(token, fileName string) error {
+	return writeToFile(token, fileName)
+}
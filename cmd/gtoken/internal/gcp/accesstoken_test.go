@@ -0,0 +1,55 @@
+package gcp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewAccessToken_request(t *testing.T) {
+	tests := []struct {
+		name         string
+		scopes       []string
+		lifetime     time.Duration
+		wantScopes   []string
+		wantLifetime string
+	}{
+		{
+			name:       "default scope",
+			wantScopes: []string{DefaultAccessTokenScope},
+		},
+		{
+			name:         "custom scopes and lifetime",
+			scopes:       []string{"https://www.googleapis.com/auth/devstorage.read_only", "openid"},
+			lifetime:     30 * time.Minute,
+			wantScopes:   []string{"https://www.googleapis.com/auth/devstorage.read_only", "openid"},
+			wantLifetime: "1800s",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := NewAccessToken(tt.scopes, tt.lifetime).(*AccessToken).request()
+			if !reflect.DeepEqual(request.Scope, tt.wantScopes) {
+				t.Errorf("request() scopes = %v, want %v", request.Scope, tt.wantScopes)
+			}
+			if request.Lifetime != tt.wantLifetime {
+				t.Errorf("request() lifetime = %q, want %q", request.Lifetime, tt.wantLifetime)
+			}
+		})
+	}
+}
+
+func TestAccessToken_GetDuration(t *testing.T) {
+	token := &AccessToken{}
+	if _, err := token.GetDuration("opaque"); err == nil {
+		t.Error("GetDuration() expected error for unknown expire time")
+	}
+	token.expiry = time.Now().Add(time.Hour)
+	got, err := token.GetDuration("opaque")
+	if err != nil {
+		t.Fatalf("GetDuration() error = %v", err)
+	}
+	if got <= 59*time.Minute || got > time.Hour {
+		t.Errorf("GetDuration() = %s, want about 1h", got)
+	}
+}
@@ -0,0 +1,70 @@
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"google.golang.org/api/iamcredentials/v1"
+)
+
+// SignedJWT generates JWT with custom claims signed by a system-managed Service Account key;
+// the JWT can be verified with the Service Account public keys (x509 endpoint)
+type SignedJWT struct {
+	claims map[string]interface{}
+	ttl    time.Duration
+}
+
+func NewSignedJWT(claims map[string]interface{}, ttl time.Duration) Token {
+	return &SignedJWT{claims: claims, ttl: ttl}
+}
+
+// payload returns JWT claims with iss and sub (unless set) and iat and exp populated
+func (t SignedJWT) payload(serviceAccount string, now time.Time) map[string]interface{} {
+	payload := make(map[string]interface{}, len(t.claims)+4)
+	for k, v := range t.claims {
+		payload[k] = v
+	}
+	if _, ok := payload["iss"]; !ok {
+		payload["iss"] = serviceAccount
+	}
+	if _, ok := payload["sub"]; !ok {
+		payload["sub"] = serviceAccount
+	}
+	payload["iat"] = now.Unix()
+	payload["exp"] = now.Add(t.ttl).Unix()
+	return payload
+}
+
+func (t SignedJWT) Generate(ctx context.Context, serviceAccount string) (string, error) {
+	log.Println("generating a new signed JWT")
+	payload, err := json.Marshal(t.payload(serviceAccount, time.Now()))
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal JWT claims: %s", err.Error())
+	}
+	iamCredentialsClient, err := newIAMCredentialsService(ctx)
+	if err != nil {
+		return "", err
+	}
+	signJwtResponse, err := iamCredentialsClient.Projects.ServiceAccounts.SignJwt(
+		fmt.Sprintf("projects/-/serviceAccounts/%s", serviceAccount),
+		&iamcredentials.SignJwtRequest{
+			Payload: string(payload),
+		},
+	).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to sign JWT: %s", err.Error())
+	}
+	log.Printf("successfully signed JWT with key: %s\n", signJwtResponse.KeyId)
+	return signJwtResponse.SignedJwt, nil
+}
+
+func (SignedJWT) GetDuration(jwtToken string) (time.Duration, error) {
+	return getJWTDuration(jwtToken)
+}
+
+func (SignedJWT) WriteToFile(token, fileName string) error {
+	return writeToFile(token, fileName)
+}
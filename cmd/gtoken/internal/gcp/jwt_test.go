@@ -0,0 +1,65 @@
+package gcp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSignedJWT_payload(t *testing.T) {
+	const serviceAccount = "test@project.iam.gserviceaccount.com"
+	now := time.Unix(1600000000, 0)
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		ttl    time.Duration
+		want   map[string]interface{}
+	}{
+		{
+			name:   "default claims",
+			claims: map[string]interface{}{},
+			ttl:    time.Hour,
+			want: map[string]interface{}{
+				"iss": serviceAccount,
+				"sub": serviceAccount,
+				"iat": now.Unix(),
+				"exp": now.Add(time.Hour).Unix(),
+			},
+		},
+		{
+			name:   "custom claims with iss and sub overrides",
+			claims: map[string]interface{}{"iss": "https://issuer.example.com", "sub": "acme", "tenant": "acme"},
+			ttl:    10 * time.Minute,
+			want: map[string]interface{}{
+				"iss":    "https://issuer.example.com",
+				"sub":    "acme",
+				"tenant": "acme",
+				"iat":    now.Unix(),
+				"exp":    now.Add(10 * time.Minute).Unix(),
+			},
+		},
+		{
+			name:   "iat and exp are always populated",
+			claims: map[string]interface{}{"iat": 1, "exp": 2},
+			ttl:    time.Hour,
+			want: map[string]interface{}{
+				"iss": serviceAccount,
+				"sub": serviceAccount,
+				"iat": now.Unix(),
+				"exp": now.Add(time.Hour).Unix(),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := len(tt.claims)
+			token := NewSignedJWT(tt.claims, tt.ttl).(*SignedJWT)
+			if got := token.payload(serviceAccount, now); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("payload() = %v, want %v", got, tt.want)
+			}
+			if len(tt.claims) != claims {
+				t.Errorf("payload() modified custom claims: %v", tt.claims)
+			}
+		})
+	}
+}
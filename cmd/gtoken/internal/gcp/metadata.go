@@ -0,0 +1,63 @@
+package gcp
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// environment variable with metadata server host:port (used by metadata package and emulator)
+	metadataHostEnv = "GCE_METADATA_HOST"
+	// default metadata server address
+	metadataIP = "169.254.169.254"
+)
+
+// CheckMetadata checks that GCE metadata server is able to issue tokens for the default service account.
+// metadata.OnGCE() caches the first result, so it cannot be used to poll a metadata server that is not ready yet;
+// metadata.Client does not accept context, so the request is sent with metadataHTTPClient to respect ctx deadline.
+func CheckMetadata(ctx context.Context) error {
+	log.Println("checking metadata server token endpoint")
+	host := os.Getenv(metadataHostEnv)
+	if host == "" {
+		host = metadataIP
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create metadata server request")
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := metadataHTTPClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "metadata server is not ready")
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("metadata server is not ready: unexpected response status: %s", resp.Status)
+	}
+	return nil
+}
+
+// PingMetadata checks that GCE metadata server is reachable and returns its response latency
+func PingMetadata() (time.Duration, error) {
+	start := time.Now()
+	if _, err := metadataClient.ProjectID(); err != nil {
+		return 0, errors.Wrap(err, "failed to reach metadata server")
+	}
+	return time.Since(start), nil
+}
+
+// GetScopes returns OAuth2 scopes of the default service account
+func GetScopes() ([]string, error) {
+	scopes, err := metadataClient.Scopes("")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get available scopes")
+	}
+	return scopes, nil
+}
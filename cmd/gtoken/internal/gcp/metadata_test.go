@@ -0,0 +1,61 @@
+package gcp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		timeout time.Duration
+		wantErr bool
+	}{
+		{
+			name: "metadata server is ready",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Metadata-Flavor") != "Google" || !strings.HasSuffix(r.URL.Path, "/service-accounts/default/token") {
+					w.WriteHeader(http.StatusBadRequest)
+				}
+			},
+			timeout: 5 * time.Second,
+		},
+		{
+			name: "metadata server is not ready",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			timeout: 5 * time.Second,
+			wantErr: true,
+		},
+		{
+			name: "metadata server does not respond before deadline",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			},
+			timeout: 100 * time.Millisecond,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+			t.Setenv(metadataHostEnv, strings.TrimPrefix(server.URL, "http://"))
+			ctx, cancel := context.WithTimeout(context.TODO(), tt.timeout)
+			defer cancel()
+			start := time.Now()
+			if err := CheckMetadata(ctx); (err != nil) != tt.wantErr {
+				t.Errorf("CheckMetadata() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if elapsed := time.Since(start); elapsed > tt.timeout+time.Second {
+				t.Errorf("CheckMetadata() took %s, context timeout %s", elapsed, tt.timeout)
+			}
+		})
+	}
+}
@@ -18,15 +18,17 @@ import (
 //
 // A client with more relaxed timeouts compared to the default one, which was
 // observed to timeout often on GKE when using Workload Identities.
-var metadataClient = metadata.NewClient(&http.Client{
+var metadataHTTPClient = &http.Client{
 	Transport: &http.Transport{
-		Dial: (&net.Dialer{
+		DialContext: (&net.Dialer{
 			Timeout:   10 * time.Second,
 			KeepAlive: 30 * time.Second,
-		}).Dial,
+		}).DialContext,
 		ResponseHeaderTimeout: 15 * time.Second, // default is 2
 	},
-})
+}
+
+var metadataClient = metadata.NewClient(metadataHTTPClient)
 
 type ServiceAccountInfo interface {
 	GetEmail() (string, error)
@@ -97,3 +99,22 @@ func (sa SaInfo) GetID(ctx context.Context) (string, error) {
 	}
 	return "", errors.New("failed to find service account ID")
 }
+
+// GetCredentialsType returns the type of Application Default Credentials:
+// credentials file type (service_account, authorized_user, external_account) or compute_metadata
+func GetCredentialsType(ctx context.Context) (string, error) {
+	creds, err := google.FindDefaultCredentials(ctx, "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to find default credentials")
+	}
+	if len(creds.JSON) == 0 {
+		return "compute_metadata", nil
+	}
+	var file struct {
+		Type string `json:"type"`
+	}
+	if err = json.Unmarshal(creds.JSON, &file); err != nil {
+		return "", errors.Wrap(err, "failed to parse credentials JSON")
+	}
+	return file.Type, nil
+}
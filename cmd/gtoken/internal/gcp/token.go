@@ -11,11 +11,14 @@ import (
 
 	"github.com/dgrijalva/jwt-go"
 	"google.golang.org/api/iamcredentials/v1"
+	"google.golang.org/api/option"
 )
 
 const (
-	// default aud
-	defaultAud = "gtoken/sts/assume-role-with-web-identity"
+	// DefaultAudience is the default ID token aud claim
+	DefaultAudience = "gtoken/sts/assume-role-with-web-identity"
+	// environment variable with IAM Service Account Credentials API endpoint
+	iamCredentialsEndpointEnv = "GTOKEN_IAM_CREDENTIALS_ENDPOINT"
 )
 
 type Token interface {
@@ -24,22 +27,41 @@ type Token interface {
 	WriteToFile(string, string) error
 }
 
-type IDToken struct{}
+type IDToken struct {
+	audience string
+}
 
-func NewIDToken() Token {
-	return &IDToken{}
+// NewIDToken creates ID token generator for audience (DefaultAudience, if empty)
+func NewIDToken(audience string) Token {
+	if audience == "" {
+		audience = DefaultAudience
+	}
+	return &IDToken{audience: audience}
+}
+
+func newIAMCredentialsService(ctx context.Context) (*iamcredentials.Service, error) {
+	var opts []option.ClientOption
+	// use custom IAM Service Account Credentials API endpoint (emulator), like GCE_METADATA_HOST for metadata server
+	if endpoint := os.Getenv(iamCredentialsEndpointEnv); endpoint != "" {
+		opts = append(opts, option.WithEndpoint(endpoint))
+	}
+	iamCredentialsClient, err := iamcredentials.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get iam credentials client: %s", err.Error())
+	}
+	return iamCredentialsClient, nil
 }
 
-func (IDToken) Generate(ctx context.Context, serviceAccount string) (string, error) {
+func (t IDToken) Generate(ctx context.Context, serviceAccount string) (string, error) {
 	log.Println("generating a new ID token")
-	iamCredentialsClient, err := iamcredentials.NewService(ctx)
+	iamCredentialsClient, err := newIAMCredentialsService(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to get iam credentials client: %s", err.Error())
+		return "", err
 	}
 	generateIDTokenResponse, err := iamCredentialsClient.Projects.ServiceAccounts.GenerateIdToken(
 		fmt.Sprintf("projects/-/serviceAccounts/%s", serviceAccount),
 		&iamcredentials.GenerateIdTokenRequest{
-			Audience:     defaultAud,
+			Audience:     t.audience,
 			IncludeEmail: true,
 		},
 	).Do()
@@ -51,24 +73,46 @@ func (IDToken) Generate(ctx context.Context, serviceAccount string) (string, err
 }
 
 func (IDToken) GetDuration(jwtToken string) (time.Duration, error) {
+	return getJWTDuration(jwtToken)
+}
+
+// getJWTDuration returns time left until JWT token expiration
+func getJWTDuration(jwtToken string) (time.Duration, error) {
+	claims, err := ParseClaims(jwtToken)
+	if err != nil {
+		return 0, err
+	}
+	exp, ok := claims["exp"].(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("failed to get expire date from JWT token")
+	}
+	unixTime, err := exp.Int64()
+	if err != nil {
+		return 0, fmt.Errorf("failed to convert expire date: %s", err.Error())
+	}
+	return time.Until(time.Unix(unixTime, 0)), nil
+}
+
+// ParseClaims returns claims of JWT token without verifying token signature
+func ParseClaims(jwtToken string) (map[string]interface{}, error) {
 	// parse JWT token
 	parser := jwt.Parser{UseJSONNumber: true, SkipClaimsValidation: true}
 	token, _, err := parser.ParseUnverified(jwtToken, jwt.MapClaims{})
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse jwtToken: %s", err.Error())
+		return nil, fmt.Errorf("failed to parse jwtToken: %s", err.Error())
 	}
 	if claims, ok := token.Claims.(jwt.MapClaims); ok {
-		var unixTime int64
-		unixTime, err = claims["exp"].(json.Number).Int64()
-		if err != nil {
-			return 0, fmt.Errorf("failed to convert expire date: %s", err.Error())
-		}
-		return time.Until(time.Unix(unixTime, 0)), nil
+		return claims, nil
 	}
-	return 0, fmt.Errorf("failed to get claims from ID token: %s", err.Error())
+	return nil, fmt.Errorf("failed to get claims from JWT token")
 }
 
 func (IDToken) WriteToFile(token, fileName string) error {
+	return writeToFile(token, fileName)
+}
+
+// writeToFile writes token to file or stdout
+func writeToFile(token, fileName string) error {
 	// this is a slice of io.Writers we will write the file to
 	var writers []io.Writer
 
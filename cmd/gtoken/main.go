@@ -2,14 +2,17 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
 	"syscall"
 	"time"
 
+	"github.com/doitintl/gtoken/emulator"
 	"github.com/doitintl/gtoken/internal/gcp"
 
 	"github.com/urfave/cli/v2"
@@ -22,7 +25,17 @@ var (
 	BuildDate = "unknown"
 )
 
-func generateIDToken(ctx context.Context, sa gcp.ServiceAccountInfo, idToken gcp.Token, file string, refresh bool) error {
+const (
+	initialMetadataBackoff = 500 * time.Millisecond
+	maxMetadataBackoff     = 10 * time.Second
+
+	// token lifetime limits; token is refreshed 30s before it expires
+	minTokenLifetime = time.Minute
+	maxTokenLifetime = 12 * time.Hour
+)
+
+// generateToken generates token (ID, access or signed JWT) with active Service Account and refreshes it before expiration
+func generateToken(ctx context.Context, sa gcp.ServiceAccountInfo, gen gcp.Token, file string, refresh bool, h *hooks) error {
 	// find out active Service Account, first by ID
 	serviceAccount, err := sa.GetID(ctx)
 	if err != nil {
@@ -43,20 +56,22 @@ func generateIDToken(ctx context.Context, sa gcp.ServiceAccountInfo, idToken gcp
 		case <-ctx.Done():
 			return nil // avoid goroutine leak
 		case <-timer:
-			// generate ID token
-			token, err := idToken.Generate(ctx, serviceAccount)
+			// generate token
+			token, err := gen.Generate(ctx, serviceAccount)
 			if err != nil {
 				return err
 			}
 			// write generated token to file or stdout
-			err = idToken.WriteToFile(token, file)
+			err = gen.WriteToFile(token, file)
 			if err != nil {
 				return err
 			}
+			// notify post-refresh hooks (non-blocking)
+			hooksDone := h.notify(ctx, file)
 			// auto-refresh enabled
 			if refresh {
 				// get token duration
-				duration, err = idToken.GetDuration(token)
+				duration, err = gen.GetDuration(token)
 				if err != nil {
 					return err
 				}
@@ -66,14 +81,129 @@ func generateIDToken(ctx context.Context, sa gcp.ServiceAccountInfo, idToken gcp
 				// reset timer
 				timer = time.NewTimer(duration).C
 			} else {
+				// wait for hooks before exit, otherwise they are killed
+				h.wait(hooksDone)
 				return nil // avoid goroutine leak
 			}
 		}
 	}
 }
 
+// waitForMetadata polls metadata server with exponential backoff until it is ready or timeout expires
+func waitForMetadata(ctx context.Context, timeout time.Duration, check func(context.Context) error) error {
+	log.Printf("waiting for metadata server (timeout %s)\n", timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	backoff := initialMetadataBackoff
+	for attempt := 1; ; attempt++ {
+		err := check(ctx)
+		if err == nil {
+			log.Printf("metadata server is ready after %d attempt(s) in %s\n", attempt, time.Since(start).Round(time.Millisecond))
+			return nil
+		}
+		log.Printf("attempt %d: %s; retrying in %s\n", attempt, err, backoff)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("metadata server is not ready after %s: %s", timeout, err.Error())
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxMetadataBackoff {
+			backoff = maxMetadataBackoff
+		}
+	}
+}
+
 func generateIDTokenCmd(c *cli.Context) error {
-	return generateIDToken(handleSignals(), gcp.NewSaInfo(), gcp.NewIDToken(), c.String("file"), c.Bool("refresh"))
+	return generateTokenCmd(c, gcp.NewIDToken(c.String("audience")))
+}
+
+// validateLifetime checks that token lifetime flag is within supported limits
+func validateLifetime(name string, lifetime time.Duration) error {
+	if lifetime < minTokenLifetime || lifetime > maxTokenLifetime {
+		return fmt.Errorf("invalid %s %s: must be between %s and %s", name, lifetime, minTokenLifetime, maxTokenLifetime)
+	}
+	return nil
+}
+
+func generateAccessTokenCmd(c *cli.Context) error {
+	if err := validateLifetime("lifetime", c.Duration("lifetime")); err != nil {
+		return err
+	}
+	return generateTokenCmd(c, gcp.NewAccessToken(c.StringSlice("scopes"), c.Duration("lifetime")))
+}
+
+func generateSignedJWTCmd(c *cli.Context) error {
+	if err := validateLifetime("ttl", c.Duration("ttl")); err != nil {
+		return err
+	}
+	claims, err := loadClaims(c.String("claims"))
+	if err != nil {
+		return err
+	}
+	return generateTokenCmd(c, gcp.NewSignedJWT(claims, c.Duration("ttl")))
+}
+
+// loadClaims loads custom JWT claims from JSON file
+func loadClaims(fileName string) (map[string]interface{}, error) {
+	claims := make(map[string]interface{})
+	if fileName == "" {
+		return claims, nil
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open claims file: %s; error: %s", fileName, err.Error())
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+	decoder.UseNumber()
+	if err = decoder.Decode(&claims); err != nil {
+		return nil, fmt.Errorf("failed to parse claims file: %s; error: %s", fileName, err.Error())
+	}
+	return claims, nil
+}
+
+// emulateCmd runs fake metadata server and IAM Service Account Credentials API
+func emulateCmd(c *cli.Context) error {
+	config := emulator.DefaultConfig()
+	config.ProjectID = c.String("project")
+	config.Email = c.String("email")
+	config.UniqueID = c.String("unique-id")
+	config.TokenLifetime = c.Duration("token-lifetime")
+	config.Latency = c.Duration("latency")
+	config.ErrorRate = c.Float64("error-rate")
+	config.ErrorCode = c.Int("error-code")
+	srv, err := emulator.New(config)
+	if err != nil {
+		return err
+	}
+	ctx := handleSignals()
+	server := &http.Server{Addr: c.String("listen"), Handler: srv}
+	go func() {
+		<-ctx.Done()
+		_ = server.Shutdown(context.Background())
+	}()
+	log.Printf("emulating metadata server and IAM Credentials API on http://%s\n", server.Addr)
+	log.Printf("export GCE_METADATA_HOST=%s\n", server.Addr)
+	log.Printf("export GTOKEN_IAM_CREDENTIALS_ENDPOINT=http://%s/\n", server.Addr)
+	if err = server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func generateTokenCmd(c *cli.Context, gen gcp.Token) error {
+	ctx := handleSignals()
+	if timeout := c.Duration("wait-for-metadata"); timeout > 0 {
+		if err := waitForMetadata(ctx, timeout, gcp.CheckMetadata); err != nil {
+			return err
+		}
+	}
+	h, err := newHooks(c.StringSlice("exec-hook"), c.StringSlice("signal-hook"), c.StringSlice("http-hook"), c.Duration("hook-timeout"))
+	if err != nil {
+		return err
+	}
+	return generateToken(ctx, gcp.NewSaInfo(), gen, c.String("file"), c.Bool("refresh"), h)
 }
 
 func handleSignals() context.Context {
@@ -100,11 +230,138 @@ func main() {
 			&cli.BoolFlag{
 				Name:  "refresh",
 				Value: false,
-				Usage: "auto refresh ID token before it expires",
+				Usage: "auto refresh token before it expires",
 			},
 			&cli.StringFlag{
 				Name:  "file",
-				Usage: "write ID token into file (stdout, if not specified)",
+				Usage: "write token into file (stdout, if not specified)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "exec-hook",
+				Usage: "run command after token is written (token file path in GTOKEN_FILE env)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "signal-hook",
+				Usage: "send signal to process after token is written; format: process[:signal] (SIGHUP, if not specified)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "http-hook",
+				Usage: "send POST request to URL after token is written",
+			},
+			&cli.DurationFlag{
+				Name:  "hook-timeout",
+				Value: 10 * time.Second,
+				Usage: "post-refresh hook timeout",
+			},
+			&cli.DurationFlag{
+				Name:  "wait-for-metadata",
+				Usage: "wait for metadata server to be ready before generating token (disabled, if not specified)",
+			},
+			&cli.StringFlag{
+				Name:  "audience",
+				Value: gcp.DefaultAudience,
+				Usage: "ID token audience (aud claim)",
+			},
+		},
+		Commands: []*cli.Command{
+			{
+				Name:  "access-token",
+				Usage: "generate OAuth2 access token instead of ID token",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "scopes",
+						Value: cli.NewStringSlice(gcp.DefaultAccessTokenScope),
+						Usage: "access token OAuth2 scopes",
+					},
+					&cli.DurationFlag{
+						Name:  "lifetime",
+						Value: time.Hour,
+						Usage: "access token lifetime (1m to 12h; over 1h, if allowed by organization policy)",
+					},
+				},
+				Action: generateAccessTokenCmd,
+			},
+			{
+				Name:  "sign-jwt",
+				Usage: "generate JWT with custom claims signed by Service Account key instead of ID token",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "claims",
+						Usage: "JSON file with custom JWT claims (iss, sub, iat and exp are populated automatically)",
+					},
+					&cli.DurationFlag{
+						Name:  "ttl",
+						Value: time.Hour,
+						Usage: "JWT time to live (1m to 12h)",
+					},
+				},
+				Action: generateSignedJWTCmd,
+			},
+			{
+				Name:  "doctor",
+				Usage: "check GKE Workload Identity, GCP Service Account and AWS role configuration",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "audience",
+						Value: gcp.DefaultAudience,
+						Usage: "expected ID token audience",
+					},
+					&cli.StringFlag{
+						Name:  "role-arn",
+						Usage: "AWS role ARN to assume with ID token (skip STS check, if not specified)",
+					},
+					&cli.StringFlag{
+						Name:  "sts-endpoint",
+						Value: defaultSTSEndpoint,
+						Usage: "AWS STS endpoint",
+					},
+				},
+				Action: doctorCmd,
+			},
+			{
+				Name:  "emulate",
+				Usage: "run fake metadata server and IAM Credentials API for offline testing",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "listen",
+						Value: "127.0.0.1:8080",
+						Usage: "emulator listen address",
+					},
+					&cli.StringFlag{
+						Name:  "project",
+						Value: emulator.DefaultConfig().ProjectID,
+						Usage: "GCP project ID",
+					},
+					&cli.StringFlag{
+						Name:  "email",
+						Value: emulator.DefaultConfig().Email,
+						Usage: "Service Account email",
+					},
+					&cli.StringFlag{
+						Name:  "unique-id",
+						Value: emulator.DefaultConfig().UniqueID,
+						Usage: "Service Account unique ID",
+					},
+					&cli.DurationFlag{
+						Name:  "token-lifetime",
+						Value: time.Hour,
+						Usage: "lifetime of generated tokens",
+					},
+					&cli.DurationFlag{
+						Name:  "latency",
+						Usage: "latency added to every response",
+					},
+					&cli.Float64Flag{
+						Name:  "error-rate",
+						Usage: "fraction (0..1) of requests to fail",
+					},
+					&cli.IntFlag{
+						Name:  "error-code",
+						Value: http.StatusServiceUnavailable,
+						Usage: "HTTP status code of failed requests",
+					},
+				},
+				Action: emulateCmd,
 			},
 		},
 		Name:    "gtoken",
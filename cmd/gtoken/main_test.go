@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
 
@@ -10,7 +14,7 @@ import (
 )
 
 //nolint:funlen
-func Test_generateIDToken(t *testing.T) {
+func Test_generateToken(t *testing.T) {
 	type args struct {
 		file    string
 		refresh bool
@@ -144,11 +148,156 @@ func Test_generateIDToken(t *testing.T) {
 				time.Sleep(time.Second)
 				cancel()
 			}()
-			if err := generateIDToken(ctx, mockSA, mockToken, tt.args.file, tt.args.refresh); (err != nil) != tt.wantErr {
-				t.Errorf("generateIDToken() error = %v, wantErr %v", err, tt.wantErr)
+			if err := generateToken(ctx, mockSA, mockToken, tt.args.file, tt.args.refresh, nil); (err != nil) != tt.wantErr {
+				t.Errorf("generateToken() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			mockSA.AssertExpectations(t)
 			mockToken.AssertExpectations(t)
 		})
 	}
 }
+
+func Test_generateToken_hooks(t *testing.T) {
+	const email, jwt, file = "test@project.iam.gserviceaccount.com", "whatever", "jwt.token"
+	ctx := context.TODO()
+	mockSA := &gcp.MockServiceAccountInfo{}
+	mockSA.On("GetID", ctx).Return(email, nil)
+	mockToken := &gcp.MockToken{}
+	mockToken.On("Generate", ctx, email).Return(jwt, nil)
+	mockToken.On("WriteToFile", jwt, file).Return(nil)
+	done := make(chan struct{})
+	h := &hooks{hooks: []hook{slowHook{delay: 100 * time.Millisecond, done: done}}, timeout: 5 * time.Second}
+	// one time token generation waits for post-refresh hooks before return
+	if err := generateToken(ctx, mockSA, mockToken, file, false, h); err != nil {
+		t.Fatalf("generateToken() error = %v", err)
+	}
+	select {
+	case <-done:
+	default:
+		t.Error("generateToken() returned before post-refresh hooks completed")
+	}
+	mockSA.AssertExpectations(t)
+	mockToken.AssertExpectations(t)
+}
+
+func Test_waitForMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		timeout  time.Duration
+		wantErr  bool
+	}{
+		{
+			name:    "metadata server is ready",
+			timeout: time.Second,
+		},
+		{
+			name:     "metadata server is ready after retry",
+			failures: 1,
+			timeout:  5 * time.Second,
+		},
+		{
+			name:     "metadata server is not ready",
+			failures: 100,
+			timeout:  100 * time.Millisecond,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			check := func(context.Context) error {
+				calls++
+				if calls <= tt.failures {
+					return errors.New("metadata server is not ready")
+				}
+				return nil
+			}
+			if err := waitForMetadata(context.TODO(), tt.timeout, check); (err != nil) != tt.wantErr {
+				t.Errorf("waitForMetadata() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_waitForMetadata_timeout(t *testing.T) {
+	// a hanging check is canceled by wait timeout
+	check := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	start := time.Now()
+	if err := waitForMetadata(context.TODO(), 100*time.Millisecond, check); err == nil {
+		t.Error("waitForMetadata() expected error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waitForMetadata() took %s, want about 100ms", elapsed)
+	}
+}
+
+func Test_loadClaims(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "claims.json")
+	_ = os.WriteFile(valid, []byte(`{"tenant": "acme", "env": "prod", "level": 3}`), 0o600)
+	invalid := filepath.Join(dir, "invalid.json")
+	_ = os.WriteFile(invalid, []byte(`{"tenant": `), 0o600)
+	tests := []struct {
+		name    string
+		file    string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name: "no claims file",
+			want: map[string]interface{}{},
+		},
+		{
+			name: "custom claims",
+			file: valid,
+			want: map[string]interface{}{"tenant": "acme", "env": "prod", "level": json.Number("3")},
+		},
+		{
+			name:    "invalid claims file",
+			file:    invalid,
+			wantErr: true,
+		},
+		{
+			name:    "missing claims file",
+			file:    filepath.Join(dir, "missing.json"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadClaims(tt.file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadClaims() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadClaims() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateLifetime(t *testing.T) {
+	tests := []struct {
+		name     string
+		lifetime time.Duration
+		wantErr  bool
+	}{
+		{name: "default", lifetime: time.Hour},
+		{name: "minimum", lifetime: time.Minute},
+		{name: "maximum", lifetime: 12 * time.Hour},
+		{name: "shorter than refresh margin", lifetime: 30 * time.Second, wantErr: true},
+		{name: "too short", lifetime: 59 * time.Second, wantErr: true},
+		{name: "too long", lifetime: 13 * time.Hour, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateLifetime("lifetime", tt.lifetime); (err != nil) != tt.wantErr {
+				t.Errorf("validateLifetime() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}